@@ -6,10 +6,14 @@ mkdir -p static/img
 
 curl -sL "https://unpkg.com/htmx.org@$HTMX_VERSION" > static/htmx.js
 curl -sL "https://unpkg.com/htmx.org/dist/ext/remove-me.js" > static/remove-me.js
+curl -sL "https://unpkg.com/htmx.org@$HTMX_VERSION/dist/ext/sse.js" > static/sse.js
 curl -sL "https://htmx.org/img/bars.svg" > static/img/bars.svg
 
 echo '<script type="text/javascript" src="/htmx.js"></script>' > layouts/partials/extended_head.html
 echo '<script type="text/javascript" src="/remove-me.js"></script>' >> layouts/partials/extended_head.html
+echo '<script type="text/javascript" src="/sse.js"></script>' >> layouts/partials/extended_head.html
+# error responses retargeted by the server are alerts, which htmx must be allowed to swap
+echo '<script type="text/javascript">document.addEventListener("htmx:beforeSwap", function (e) { if (e.detail.isError && e.detail.xhr.getResponseHeader("HX-Retarget")) { e.detail.shouldSwap = true; e.detail.isError = false; } });</script>' >> layouts/partials/extended_head.html
 echo "<meta name=\"htmx-config\" content='{\"withCredentials\": true}'>" >> layouts/partials/extended_head.html
 echo '<script defer data-domain="garbagespeak.com" src="/js/script.tagged-events.js"></script>' >> layouts/partials/extended_head.html
 
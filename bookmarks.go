@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+)
+
+func (s *Server) addBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	_, err := s.store.Exec(ctx,
+		`INSERT INTO bookmarks(user_id, garbage_id)
+		SELECT $1, id FROM garbages WHERE id = $2 AND published_at IS NOT NULL
+		ON CONFLICT (user_id, garbage_id) DO NOTHING`,
+		userID,
+		garbageID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderBookmarkButton(ctx, w, r, garbageID, userID)
+}
+
+// removeBookmarkHandler removes garbage from the user's bookmarks, and from all of the user's collections
+func (s *Server) removeBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "DELETE FROM bookmarks WHERE user_id = $1 AND garbage_id = $2", userID, garbageID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM collection_items USING collections
+		WHERE collection_items.collection_id = collections.id
+		AND collections.owner_id = $1
+		AND collection_items.garbage_id = $2`,
+		userID,
+		garbageID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderBookmarkButton(ctx, w, r, garbageID, userID)
+}
+
+// renderBookmarkButton renders garbage's bookmark button as seen by the given user
+func (s *Server) renderBookmarkButton(ctx context.Context, w http.ResponseWriter, r *http.Request, garbageID, userID string) {
+	garbage := Garbage{}
+	err := pgxscan.Get(ctx, s.store, &garbage, "SELECT id, "+bookmarkedColumn+" FROM garbages WHERE id = $2", userID, garbageID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.writeTemplate(ctx, w, "garbage", "bookmark_button.tmpl", map[string]any{
+		"Garbage":    garbage,
+		"ApiBaseUrl": s.config.APIURL(),
+		"UserID":     userID,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// listBookmarksHandler returns the current user's collections and bookmarked garbage
+func (s *Server) listBookmarksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		w.Header().Add("hx-location", fmt.Sprintf("%s/users/login", s.config.AppURL()))
+		return
+	}
+
+	ctx := r.Context()
+	collections := []*Collection{}
+	err := pgxscan.Select(ctx, s.store, &collections,
+		"SELECT id, owner_id, name, is_public, created_at FROM collections WHERE owner_id = $1 ORDER BY name",
+		userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	query := `SELECT
+			garbages.id, n, owner_id, username, title, rendered_content, render_version, metadata, url, link_preview, uplevel_count,
+			` + upleveledColumn + `, ` + bookmarkedColumn + `, ` + followingColumn + `,
+			reaction_counts, ` + reactionsColumn + `, garbages.created_at, published_at, publish_at
+			FROM garbages
+			JOIN users ON garbages.owner_id = users.id
+			JOIN bookmarks ON bookmarks.garbage_id = garbages.id
+			WHERE bookmarks.user_id = $1 AND published_at IS NOT NULL`
+	pagedQuery, args := pagedQuery(r, query, userID)
+
+	garbage := []*Garbage{}
+	err = pgxscan.Select(ctx, s.store, &garbage, pagedQuery, args...)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	var lastItem *int
+	if len(garbage) > 0 {
+		lastItem = &(garbage[len(garbage)-1].N)
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "collections", "index.html", map[string]any{
+		"Collections": collections,
+		"ApiBaseUrl":  s.config.APIURL(),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.templates.Render(r.Context(), buff, "collections", "list.html", map[string]any{
+		"Posts":         garbage,
+		"ApiBaseUrl":    s.config.APIURL(),
+		"LoggedIn":      true,
+		"UserID":        userID,
+		"IsModerator":   s.isModerator(r),
+		"NextPage":      nextPageURL(r, fmt.Sprintf("%s/bookmarks/", s.config.APIURL()), lastItem),
+		"ReactionTypes": s.reactionTypes,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
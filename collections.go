@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Collection represents 'collections' records from the database. Collections are named groups of a user's bookmarks.
+type Collection struct {
+	ID        uuid.UUID
+	OwnerID   uuid.UUID
+	Username  string
+	Name      string
+	IsPublic  bool
+	CreatedAt time.Time
+	Contains  bool // whether the collection contains the garbage being organized
+}
+
+// filenameSeparatorPattern matches the runs of characters that are replaced with dashes in exported filenames
+var filenameSeparatorPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// getCollection returns the collection with the given ID, if it's visible to the user. Public collections are visible to
+// everyone, and private collections only to their owners.
+func (s *Server) getCollection(ctx context.Context, collectionID, userID string) (collection Collection, err error) {
+	err = pgxscan.Get(ctx, s.store, &collection,
+		`SELECT collections.id, owner_id, username, name, is_public, collections.created_at
+		FROM collections
+		JOIN users ON collections.owner_id = users.id
+		WHERE collections.id = $2 AND (is_public OR owner_id = NULLIF($1, '')::uuid)`,
+		userID,
+		collectionID)
+	return
+}
+
+func (s *Server) createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		formError(w, "collection-error", "Please name your collection.")
+		return
+	}
+
+	var collectionID string
+	err := s.store.QueryRow(r.Context(),
+		`INSERT INTO collections(owner_id, name, is_public) VALUES ($1, $2, $3)
+		ON CONFLICT (owner_id, name) DO NOTHING
+		RETURNING id`,
+		userID,
+		name,
+		r.PostForm.Get("is_public") == "true").Scan(&collectionID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		formError(w, "collection-error", "You already have a collection with that name.")
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Add("hx-location", fmt.Sprintf("%s/collections/%s", s.config.APIURL(), collectionID))
+}
+
+// showCollectionHandler returns a collection's garbage
+func (s *Server) showCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionID := chi.URLParam(r, "collection_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	ctx := r.Context()
+
+	collection, err := s.getCollection(ctx, collectionID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.renderError(w, r, notFound("That collection doesn't exist, or it's private.", err))
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	query := `SELECT
+			garbages.id, n, owner_id, username, title, rendered_content, render_version, metadata, url, link_preview, uplevel_count,
+			` + upleveledColumn + `, ` + bookmarkedColumn + `, ` + followingColumn + `,
+			reaction_counts, ` + reactionsColumn + `, garbages.created_at, published_at, publish_at
+			FROM garbages
+			JOIN users ON garbages.owner_id = users.id
+			JOIN collection_items ON collection_items.garbage_id = garbages.id
+			WHERE collection_items.collection_id = $2 AND published_at IS NOT NULL`
+	pagedQuery, args := pagedQuery(r, query, userID, collectionID)
+
+	garbage := []*Garbage{}
+	err = pgxscan.Select(ctx, s.store, &garbage, pagedQuery, args...)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	var lastItem *int
+	if len(garbage) > 0 {
+		lastItem = &(garbage[len(garbage)-1].N)
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "collections", "show.html", map[string]any{
+		"Collection": collection,
+		"ApiBaseUrl": s.config.APIURL(),
+		"UserID":     userID,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.templates.Render(r.Context(), buff, "collections", "list.html", map[string]any{
+		"Posts":         garbage,
+		"ApiBaseUrl":    s.config.APIURL(),
+		"LoggedIn":      isLoggedIn(r),
+		"UserID":        userID,
+		"IsModerator":   s.isModerator(r),
+		"NextPage":      nextPageURL(r, fmt.Sprintf("%s/collections/%s", s.config.APIURL(), collection.ID), lastItem),
+		"ReactionTypes": s.reactionTypes,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// updateCollectionHandler renames a collection, or changes whether it's public
+func (s *Server) updateCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionID := chi.URLParam(r, "collection_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostForm.Get("name"))
+	if name == "" {
+		formError(w, "collection-error", "Please name your collection.")
+		return
+	}
+
+	// collections that are neither public nor the user's own don't exist as far as the user is concerned
+	collection, err := s.getCollection(r.Context(), collectionID, userID)
+	if pgxscan.NotFound(err) {
+		s.renderError(w, r, notFound("That collection doesn't exist.", err))
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if collection.OwnerID.String() != userID {
+		s.renderError(w, r, forbidden("Only its owner may change a collection."))
+		return
+	}
+
+	var renamed int
+	err = s.store.QueryRow(r.Context(),
+		`WITH renamed AS (
+			UPDATE collections SET (name, is_public) = ($1, $2)
+			WHERE id = $3 AND owner_id = $4
+			AND NOT EXISTS (SELECT 1 FROM collections c WHERE c.owner_id = $4 AND c.name = $1 AND c.id <> $3)
+			RETURNING id
+		)
+		SELECT COUNT(*) FROM renamed`,
+		name,
+		r.PostForm.Get("is_public") == "true",
+		collectionID,
+		userID).Scan(&renamed)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if renamed == 0 {
+		formError(w, "collection-error", "You already have a collection with that name.")
+		return
+	}
+
+	s.showCollectionHandler(w, r)
+}
+
+func (s *Server) deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionID := chi.URLParam(r, "collection_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	_, err := s.store.Exec(r.Context(), "DELETE FROM collections WHERE id = $1 AND owner_id = $2", collectionID, userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Add("hx-location", fmt.Sprintf("%s/bookmarks/", s.config.APIURL()))
+}
+
+// exportCollectionHandler exports a collection's garbage as a markdown document
+func (s *Server) exportCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	collectionID := chi.URLParam(r, "collection_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	ctx := r.Context()
+
+	collection, err := s.getCollection(ctx, collectionID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.renderError(w, r, notFound("That collection doesn't exist, or it's private.", err))
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	garbage := []*Garbage{}
+	err = pgxscan.Select(ctx, s.store, &garbage,
+		`SELECT garbages.id, title, content, url, metadata
+		FROM garbages
+		JOIN collection_items ON collection_items.garbage_id = garbages.id
+		WHERE collection_items.collection_id = $1 AND published_at IS NOT NULL
+		ORDER BY collection_items.created_at`,
+		collectionID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// link text may not contain unescaped brackets
+	escapeLinkText := strings.NewReplacer("[", "\\[", "]", "\\]")
+
+	var doc strings.Builder
+	fmt.Fprintf(&doc, "# %s\n\n", collection.Name)
+	fmt.Fprintf(&doc, "Collected by %s: %s/collections/%s\n", collection.Username, s.config.APIURL(), collection.ID)
+	for _, g := range garbage {
+		fmt.Fprintf(&doc, "\n## [%s](%s/garbage/%s)\n\n", escapeLinkText.Replace(g.Title), s.config.APIURL(), g.ID)
+		for _, line := range strings.Split(strings.TrimSpace(g.Content), "\n") {
+			fmt.Fprintf(&doc, "> %s\n", strings.TrimRight(line, "\r"))
+		}
+
+		if g.Url != "" {
+			fmt.Fprintf(&doc, "\nSeen at: <%s>\n", g.Url)
+		}
+
+		if tags, ok := g.Metadata["tags"].([]any); ok && len(tags) > 0 {
+			names := []string{}
+			for _, tag := range tags {
+				names = append(names, fmt.Sprint(tag))
+			}
+			fmt.Fprintf(&doc, "\nTags: %s\n", strings.Join(names, ", "))
+		}
+	}
+
+	filename := strings.Trim(filenameSeparatorPattern.ReplaceAllString(strings.ToLower(collection.Name), "-"), "-")
+	if filename == "" {
+		filename = "collection"
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.md"`, filename))
+	w.Write([]byte(doc.String()))
+}
+
+// garbageCollectionsHandler returns a form for choosing which of the user's collections contain garbage
+func (s *Server) garbageCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	s.renderGarbageCollections(w, r, false)
+}
+
+// updateGarbageCollectionsHandler sets which of the user's collections contain garbage. Garbage that's added to a
+// collection is also bookmarked.
+func (s *Server) updateGarbageCollectionsHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	collectionIDs := r.PostForm["collection_id"]
+	if collectionIDs == nil {
+		collectionIDs = []string{}
+	}
+
+	ctx := r.Context()
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM collection_items USING collections
+		WHERE collection_items.collection_id = collections.id
+		AND collections.owner_id = $1
+		AND collection_items.garbage_id = $2
+		AND NOT (collections.id::text = ANY($3))`,
+		userID,
+		garbageID,
+		collectionIDs)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// only the user's own collections, and only published garbage, may be collected
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO collection_items(collection_id, garbage_id)
+		SELECT collections.id, garbages.id FROM collections, garbages
+		WHERE collections.owner_id = $1 AND collections.id::text = ANY($3)
+		AND garbages.id = $2 AND garbages.published_at IS NOT NULL
+		ON CONFLICT (collection_id, garbage_id) DO NOTHING`,
+		userID,
+		garbageID,
+		collectionIDs)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if tag.RowsAffected() > 0 {
+		_, err = tx.Exec(ctx,
+			"INSERT INTO bookmarks(user_id, garbage_id) VALUES ($1, $2) ON CONFLICT (user_id, garbage_id) DO NOTHING",
+			userID,
+			garbageID)
+		if err != nil {
+			s.renderError(w, r, err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderGarbageCollections(w, r, true)
+}
+
+// renderGarbageCollections renders the user's collections, and whether each contains the garbage being organized
+func (s *Server) renderGarbageCollections(w http.ResponseWriter, r *http.Request, saved bool) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	collections := []*Collection{}
+	err := pgxscan.Select(r.Context(), s.store, &collections,
+		`SELECT id, owner_id, name, is_public, created_at, EXISTS (
+			SELECT 1 FROM collection_items
+			WHERE collection_items.collection_id = collections.id AND collection_items.garbage_id = $2
+		) AS contains
+		FROM collections
+		WHERE owner_id = $1
+		ORDER BY name`,
+		userID,
+		garbageID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.writeTemplate(r.Context(), w, "collections", "garbage_collections.html", map[string]any{
+		"Collections": collections,
+		"GarbageID":   garbageID,
+		"ApiBaseUrl":  s.config.APIURL(),
+		"Saved":       saved,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
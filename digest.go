@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acaloiaro/neoq/backends/postgres"
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// digestWeek returns the week that a digest sent at t belongs to, identified by the Monday on which the week starts.
+// Digests contain the garbage posted during the week before their week.
+func digestWeek(t time.Time) time.Time {
+	t = t.UTC()
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return time.Date(t.Year(), t.Month(), t.Day()-daysSinceMonday, 0, 0, 0, 0, time.UTC)
+}
+
+// weeklyDigestHandler queues a weekly digest email for every user who opted in to receiving them
+func (s *Server) weeklyDigestHandler(ctx context.Context) (err error) {
+	week := digestWeek(time.Now())
+
+	userIDs := []string{}
+	err = pgxscan.Select(ctx, s.store, &userIDs, "SELECT id FROM users WHERE digest_opt_in")
+	if err != nil {
+		return
+	}
+
+	for _, userID := range userIDs {
+		err = s.enqueue(ctx, &jobs.Job{
+			Queue: "weekly_digest_email",
+			Payload: map[string]any{
+				"user_id": userID,
+				"week":    week.Format(time.DateOnly),
+			},
+		})
+		if err != nil && !errors.Is(err, postgres.ErrDuplicateJob) {
+			return
+		}
+	}
+
+	return nil
+}
+
+// weeklyDigestEmailHandler sends a user the most upleveled garbage of the past week
+func (s *Server) weeklyDigestEmailHandler(ctx context.Context) (err error) {
+	var j *jobs.Job
+	j, err = jobs.FromContext(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to process weekly digest email", "error", err)
+		return
+	}
+	userID := j.Payload["user_id"].(string)
+	week, err := time.Parse(time.DateOnly, j.Payload["week"].(string))
+	if err != nil {
+		return
+	}
+
+	if s.config.SecretKey == "" {
+		return errors.New("SECRET_KEY is not set, digests cannot include unsubscribe links")
+	}
+
+	// the send is claimed, and the claim committed, before the email is sent, so that neither a slow mail server nor a
+	// failure after sending leaves a transaction open or the claim undone. Only sends that are known to have failed are
+	// claimed again by retries; a send whose outcome is unknown, e.g. because the job died while sending, is never
+	// repeated, since users are better off missing a digest than receiving it twice.
+	var recipient string
+	err = s.store.QueryRow(ctx,
+		`WITH claim AS (
+			INSERT INTO digest_sends(user_id, week) VALUES ($1, $2)
+			ON CONFLICT (user_id, week) DO UPDATE SET status = 'sending', claimed_at = now()
+			WHERE digest_sends.status = 'failed'
+			RETURNING user_id
+		)
+		SELECT email FROM users JOIN claim ON claim.user_id = users.id WHERE digest_opt_in`,
+		userID,
+		week).Scan(&recipient)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// this week's digest was already sent, or the user opted out since the digest was queued
+		return nil
+	}
+	if err != nil {
+		return
+	}
+
+	garbage := []*Garbage{}
+	err = pgxscan.Select(ctx, s.store, &garbage,
+		`SELECT id, title, uplevel_count FROM garbages
+		WHERE published_at >= $1 AND published_at < $2 AND uplevel_count > 0
+		ORDER BY uplevel_count DESC, n DESC
+		LIMIT 10`,
+		week.AddDate(0, 0, -7),
+		week)
+	if err != nil {
+		return errors.Join(err, s.recordDigestSend(ctx, userID, week, "failed"))
+	}
+
+	// nothing was upleveled last week, so there's nothing to send
+	if len(garbage) == 0 {
+		return s.recordDigestSend(ctx, userID, week, "skipped")
+	}
+
+	unsubscribe := s.unsubscribeURL(userID)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "The most upleveled garbage of the week of %s:\r\n\r\n", week.AddDate(0, 0, -7).Format("January 2"))
+	for i, g := range garbage {
+		fmt.Fprintf(&body, "%d. %s (%d uplevels)\r\n   %s/garbage/%s\r\n\r\n", i+1, g.Title, g.UplevelCount, s.config.APIURL(), g.ID)
+	}
+	fmt.Fprintf(&body, "To stop receiving these emails, unsubscribe by visiting: %s\r\n", unsubscribe)
+
+	err = s.mailer.Send(recipient, "The worst garbage of the week", body.String(), map[string]string{
+		"List-Unsubscribe":      fmt.Sprintf("<%s>", unsubscribe),
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	})
+	if err != nil {
+		s.metrics.EmailFailed("weekly_digest")
+		return errors.Join(err, s.recordDigestSend(ctx, userID, week, "failed"))
+	}
+
+	return s.recordDigestSend(ctx, userID, week, "sent")
+}
+
+// recordDigestSend records the outcome of sending a user's digest for the given week, which was claimed by the caller
+func (s *Server) recordDigestSend(ctx context.Context, userID string, week time.Time, status string) error {
+	_, err := s.store.Exec(ctx,
+		`UPDATE digest_sends SET status = $3, sent_at = CASE WHEN $3 = 'sent' THEN now() END
+		WHERE user_id = $1 AND week = $2 AND status = 'sending'`,
+		userID,
+		week,
+		status)
+	if err != nil {
+		return fmt.Errorf("unable to record %s weekly digest: %w", status, err)
+	}
+
+	return nil
+}
+
+// unsubscribeSignature signs a user ID, so that unsubscribe links work without users having to log in
+func (s *Server) unsubscribeSignature(userID string) string {
+	mac := hmac.New(sha256.New, []byte(s.config.SecretKey))
+	mac.Write([]byte(fmt.Sprintf("digest_unsubscribe:%s", userID)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// unsubscribeURL returns the URL with which a user unsubscribes from the weekly digest
+func (s *Server) unsubscribeURL(userID string) string {
+	return fmt.Sprintf("%s/users/%s/unsubscribe/%s", s.config.APIURL(), userID, s.unsubscribeSignature(userID))
+}
+
+// unsubscribePageHandler asks users who followed the unsubscribe link in their email to confirm that they want to
+// unsubscribe from the weekly digest. GET requests don't unsubscribe, since link scanners and prefetchers follow links.
+func (s *Server) unsubscribePageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+	if !s.validUnsubscribeSignature(userID, chi.URLParam(r, "signature")) {
+		s.renderError(w, r, forbidden("This unsubscribe link is invalid."))
+		return
+	}
+
+	buff := bytes.NewBufferString("")
+	err := s.templates.Render(r.Context(), buff, "users", "unsubscribe.html", map[string]any{
+		"ApiBaseUrl":     s.config.APIURL(),
+		"UnsubscribeURL": s.unsubscribeURL(userID),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// unsubscribeHandler unsubscribes users from the weekly digest, either when they confirm on the unsubscribe page, or
+// when their email client unsubscribes with one click (RFC 8058)
+func (s *Server) unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+	if !s.validUnsubscribeSignature(userID, chi.URLParam(r, "signature")) {
+		s.renderError(w, r, forbidden("This unsubscribe link is invalid."))
+		return
+	}
+
+	_, err := s.store.Exec(r.Context(), "UPDATE users SET digest_opt_in = false WHERE id = $1", userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "users", "unsubscribed.html", map[string]any{"ApiBaseUrl": s.config.APIURL()})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// email clients unsubscribing with one click don't display the response
+	if r.PostFormValue("List-Unsubscribe") == "One-Click" {
+		w.Write(buff.Bytes())
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// validUnsubscribeSignature returns whether signature is userID's unsubscribe signature
+func (s *Server) validUnsubscribeSignature(userID, signature string) bool {
+	return s.config.SecretKey != "" && hmac.Equal([]byte(signature), []byte(s.unsubscribeSignature(userID)))
+}
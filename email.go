@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/acaloiaro/neoq/jobs"
+)
+
+// sendWelcomeEmail sends an email to recipient containing a special URL that only that can know, for the purpose of
+// email address verification
+func (s *Server) sendWelcomeEmail(recipient, verificationURL, siteName string) error {
+	return s.mailer.Send(recipient,
+		fmt.Sprintf("Welcome to %s!", siteName),
+		fmt.Sprintf("Verify your email address by visiting: %s\r\n", verificationURL),
+		nil)
+}
+
+// smtpMailer sends email through an SMTP server
+type smtpMailer struct {
+	host     string // the SMTP server's host:port
+	username string
+	password string
+	from     string // the address from which email is sent
+}
+
+func (m *smtpMailer) Send(recipient, subject, body string, headers map[string]string) (err error) {
+	smtpHost := m.host
+	from := m.from
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	for name, value := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", name, value)
+	}
+	msg.WriteString("\r\n")
+	msg.WriteString(body)
+
+	host, _, _ := net.SplitHostPort(smtpHost)
+
+	auth := smtp.PlainAuth("", m.username, m.password, host)
+
+	// Here is the key, you need to call tls.Dial instead of smtp.Dial
+	// for smtp servers running on 465 that require an ssl connection
+	// from the very beginning (no starttls)
+	c, err := smtp.Dial(smtpHost)
+	if err != nil {
+		return
+	}
+	defer c.Close()
+
+	// TLS config
+	tlsconfig := &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         host,
+	}
+	c.StartTLS(tlsconfig)
+
+	// Auth
+	if err = c.Auth(auth); err != nil {
+		return
+	}
+
+	// From
+	if err = c.Mail(from); err != nil {
+		return
+	}
+
+	// Recipient
+	if err = c.Rcpt(recipient); err != nil {
+		return
+	}
+
+	// Data
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+
+	_, err = w.Write([]byte(msg.String()))
+	if err != nil {
+		return
+	}
+
+	err = w.Close()
+	if err != nil {
+		return
+	}
+
+	c.Quit()
+
+	return
+}
+
+// welcomeEmailHandler sends a welcome email to new users
+func (s *Server) welcomeEmailHandler(ctx context.Context) (err error) {
+	var j *jobs.Job
+	j, err = jobs.FromContext(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to process welcome email", "error", err)
+		return
+	}
+	recipient := j.Payload["recipient"].(string)
+	verificationURL := j.Payload["verification_url"].(string)
+	err = s.sendWelcomeEmail(recipient, verificationURL, "Garbage Speak")
+	if err != nil {
+		s.metrics.EmailFailed("welcome")
+	}
+
+	return
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AppError is an error whose status and message are shown to users. Err, the error that caused it, may contain internal
+// details such as SQL, so it's only ever logged.
+type AppError struct {
+	Status  int    // the HTTP status with which to respond
+	Message string // a message that's safe to show users
+	Err     error  // the error that caused this error, if any
+}
+
+func (e *AppError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+
+	return e.Message
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// errUnauthorized is returned to users who must be logged in to do what they tried
+var errUnauthorized = &AppError{Status: http.StatusUnauthorized, Message: "You must be logged in to do that."}
+
+// errForbidden is returned to logged in users who aren't permitted to do what they tried, e.g. moderate garbage
+var errForbidden = &AppError{Status: http.StatusForbidden, Message: "You aren't allowed to do that."}
+
+// notFound returns an error reporting that what a user asked for doesn't exist
+func notFound(message string, err error) error {
+	return &AppError{Status: http.StatusNotFound, Message: message, Err: err}
+}
+
+// badRequest returns an error reporting that a user's request is invalid
+func badRequest(message string, err error) error {
+	return &AppError{Status: http.StatusBadRequest, Message: message, Err: err}
+}
+
+// forbidden returns an error reporting that a user isn't permitted to do what they tried
+func forbidden(message string) error {
+	return &AppError{Status: http.StatusForbidden, Message: message}
+}
+
+// renderError responds with the error that occurred while serving r. Users see the error's status and a message
+// that's safe to show them; internal details, such as SQL errors, are only logged. htmx requests are answered with an
+// alert that's added to the page they were made from, and other requests with a full error page.
+func (s *Server) renderError(w http.ResponseWriter, r *http.Request, err error) {
+	appErr := toAppError(err)
+	ctx := r.Context()
+
+	if appErr.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "unable to serve request", "status", appErr.Status, "error", err)
+		trace.SpanFromContext(ctx).RecordError(err)
+	} else {
+		slog.InfoContext(ctx, "request refused", "status", appErr.Status, "error", err)
+	}
+
+	data := map[string]any{
+		"Status":     appErr.Status,
+		"StatusText": http.StatusText(appErr.Status),
+		"Message":    appErr.Message,
+		"LoggedIn":   isLoggedIn(r),
+	}
+
+	buff := bytes.NewBufferString("")
+	if isPartialRequest(r) {
+		// htmx doesn't swap error responses by default; the site's head allows it for responses that are retargeted
+		w.Header().Set("HX-Retarget", "body")
+		w.Header().Set("HX-Reswap", "beforeend")
+		err = s.templates.Render(ctx, buff, "errors", "alert.html", data)
+	} else {
+		err = s.templates.Render(ctx, buff, "errors", "page.html", data)
+		if err == nil {
+			page := bytes.NewBufferString("")
+			err = s.templates.Page(ctx, page, buff.String(), http.StatusText(appErr.Status))
+			buff = page
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to render error", "error", err)
+		http.Error(w, http.StatusText(appErr.Status), appErr.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(appErr.Status)
+	w.Write(buff.Bytes())
+}
+
+// toAppError converts err to an AppError. Errors that aren't AppErrors are internal server errors, except for missing
+// rows and malformed IDs, which are not found.
+func toAppError(err error) *AppError {
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	if pgxscan.NotFound(err) || isInvalidID(err) {
+		return &AppError{Status: http.StatusNotFound, Message: "We couldn't find what you were looking for.", Err: err}
+	}
+
+	return &AppError{Status: http.StatusInternalServerError, Message: "Something went wrong. Please try again.", Err: err}
+}
+
+// isInvalidID returns whether err is Postgres refusing a malformed UUID. IDs come from URLs, so malformed IDs identify
+// things that don't exist.
+func isInvalidID(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "22P02" // invalid_text_representation
+}
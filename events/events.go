@@ -0,0 +1,226 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	// bufferSize is the number of events that may be waiting to be sent to a client before the client is considered too
+	// slow to keep up, and is disconnected
+	bufferSize = 32
+
+	// heartbeatInterval is how often idle connections are sent a comment to keep them from being closed by proxies, and
+	// to detect clients that have gone away
+	heartbeatInterval = 20 * time.Second
+
+	// retryInterval is the longest that the broker waits before reconnecting to Postgres after its listener fails
+	retryInterval = 30 * time.Second
+
+	// writeTimeout is how long a single write to a client may take. Streams outlive the server's write timeout, so each
+	// write gets its own deadline instead.
+	writeTimeout = 10 * time.Second
+)
+
+// Event is a server-sent event
+type Event struct {
+	Name string // the event's name, which htmx elements refer to with sse-swap
+	Data string // the event's data, usually an HTML fragment
+}
+
+// Broker fans Postgres notifications out to every connected server-sent event client.
+//
+// Every server instance runs its own broker, and each broker listens for notifications independently, so events reach
+// clients regardless of which instance they're connected to.
+type Broker struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+// NewBroker returns a Broker without subscribers
+func NewBroker() *Broker {
+	return &Broker{subscribers: map[chan Event]struct{}{}}
+}
+
+// Subscribe subscribes to all events published after subscribing. The returned channel is closed when unsubscribe is
+// called, or when the subscriber fails to keep up with published events.
+func (b *Broker) Subscribe() (events <-chan Event, unsubscribe func()) {
+	ch := make(chan Event, bufferSize)
+
+	b.mu.Lock()
+	if b.closed {
+		close(ch)
+	} else {
+		b.subscribers[ch] = struct{}{}
+	}
+	b.mu.Unlock()
+
+	unsubscribe = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.remove(ch)
+	}
+
+	return ch, unsubscribe
+}
+
+// remove removes a subscriber. The caller must hold b.mu
+func (b *Broker) remove(ch chan Event) {
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Close disconnects every subscriber, and any that subscribe afterward. Clients' event streams end, so that they don't
+// hold up the server's shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		b.remove(ch)
+	}
+}
+
+// Publish publishes an event to all subscribers. Publishing never blocks; subscribers whose buffers are full are
+// disconnected rather than allowed to hold up everyone else.
+func (b *Broker) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			b.remove(ch)
+		}
+	}
+}
+
+// Listen listens for notifications on the given Postgres channel until ctx is done, publishing an event for each
+// notification. toEvent converts notification payloads to events; notifications for which it returns an error are not
+// published. When the listening connection fails, Listen reconnects with exponential backoff.
+func (b *Broker) Listen(ctx context.Context, pool *pgxpool.Pool, channel string, toEvent func(payload string) (Event, error)) {
+	backoff := time.Second
+	for {
+		err := b.listen(ctx, pool, channel, toEvent, func() { backoff = time.Second })
+		if ctx.Err() != nil {
+			return
+		}
+
+		slog.WarnContext(ctx, "event listener failed, reconnecting", "channel", channel, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff = min(backoff*2, retryInterval)
+	}
+}
+
+func (b *Broker) listen(ctx context.Context, pool *pgxpool.Pool, channel string, toEvent func(string) (Event, error), connected func()) (err error) {
+	pooledConn, err := pool.Acquire(ctx)
+	if err != nil {
+		return
+	}
+
+	// the connection remains subscribed to the channel for as long as it's open, so it's removed from the pool rather
+	// than being returned to it
+	conn := pooledConn.Hijack()
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(ctx, fmt.Sprintf("LISTEN %s", channel))
+	if err != nil {
+		return
+	}
+	connected()
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		event, err := toEvent(notification.Payload)
+		if err != nil {
+			slog.ErrorContext(ctx, "unable to handle notification", "channel", channel, "error", err)
+			continue
+		}
+
+		b.Publish(event)
+	}
+}
+
+// ServeHTTP streams events to a client until the client disconnects, or the broker is closed
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// not every ResponseWriter supports deadlines; without them, the stream is subject to the server's write timeout
+	rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// prevent proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	events, unsubscribe := b.Subscribe()
+	defer unsubscribe()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		case event, ok := <-events:
+			if !ok {
+				// the client fell too far behind, or the broker closed; it reconnects automatically
+				return
+			}
+			rc.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err = writeEvent(w, event)
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes an event in the text/event-stream format. Each line of data must be prefixed separately.
+func writeEvent(w io.Writer, e Event) (err error) {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "event: %s\n", e.Name)
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&msg, "data: %s\n", line)
+	}
+	msg.WriteString("\n")
+
+	_, err = io.WriteString(w, msg.String())
+	return
+}
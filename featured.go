@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// featuredGarbagePickHandler picks today's garbage of the day
+func (s *Server) featuredGarbagePickHandler(ctx context.Context) (err error) {
+	return s.pickFeaturedGarbage(ctx, featuredDate(time.Now()))
+}
+
+// featuredDate returns the date whose garbage of the day is featured at t
+func featuredDate(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// pickFeaturedGarbage picks the garbage of the day for date, unless garbage was already picked or pinned for that date.
+//
+// Garbage is picked at random, weighted by uplevels, so that the most upleveled garbage is the most likely to be picked
+// without always being picked. Garbage that was featured before, or that was reported, is never picked.
+func (s *Server) pickFeaturedGarbage(ctx context.Context, date time.Time) (err error) {
+	// weighted random sampling: each row's key is -ln(u)/weight, for u uniform in (0, 1]; the smallest key wins
+	_, err = s.store.Exec(ctx,
+		`INSERT INTO featured(date, garbage_id)
+		SELECT $1, id FROM garbages
+		WHERE published_at IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM featured WHERE featured.garbage_id = garbages.id)
+		AND NOT EXISTS (SELECT 1 FROM garbage_reports WHERE garbage_reports.garbage_id = garbages.id)
+		ORDER BY -ln(1.0 - random()) / (uplevel_count + 1)
+		LIMIT 1
+		ON CONFLICT (date) DO NOTHING`,
+		date)
+
+	return
+}
+
+// featuredGarbageHandler returns the most recent garbage of the day
+func (s *Server) featuredGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	ctx := r.Context()
+
+	featured := struct {
+		Date time.Time
+		Garbage
+	}{}
+	err := pgxscan.Get(ctx, s.store, &featured,
+		`SELECT featured.date, garbages.id, owner_id, username, title, rendered_content, render_version, metadata, url, link_preview,
+			uplevel_count, `+upleveledColumn+`, `+bookmarkedColumn+`, `+followingColumn+`,
+			reaction_counts, `+reactionsColumn+`, garbages.created_at, published_at, publish_at
+			FROM featured
+			JOIN garbages ON featured.garbage_id = garbages.id
+			JOIN users ON garbages.owner_id = users.id
+			WHERE featured.date <= $2 AND published_at IS NOT NULL
+			ORDER BY featured.date DESC
+			LIMIT 1`,
+		userID,
+		featuredDate(time.Now()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		// nothing has been featured yet
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "garbage", "featured.html", map[string]any{
+		"Date":          featured.Date,
+		"Garbage":       featured.Garbage,
+		"ApiBaseUrl":    s.config.APIURL(),
+		"LoggedIn":      isLoggedIn(r),
+		"UserID":        userID,
+		"IsModerator":   s.isModerator(r),
+		"ReactionTypes": s.reactionTypes,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// featuredArchiveHandler returns every past garbage of the day
+func (s *Server) featuredArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	featured := []struct {
+		Date         time.Time
+		GarbageID    uuid.UUID
+		Title        string
+		UplevelCount int
+	}{}
+	err := pgxscan.Select(r.Context(), s.store, &featured,
+		`SELECT featured.date, featured.garbage_id, garbages.title, garbages.uplevel_count
+			FROM featured
+			JOIN garbages ON featured.garbage_id = garbages.id
+			WHERE featured.date <= $1 AND garbages.published_at IS NOT NULL
+			ORDER BY featured.date DESC`,
+		featuredDate(time.Now()))
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "garbage", "featured_archive.html", map[string]any{
+		"Featured":   featured,
+		"ApiBaseUrl": s.config.APIURL(),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// pinFeaturedGarbageHandler lets moderators pin garbage as the garbage of the day for a date, replacing whatever was
+// picked for that date
+func (s *Server) pinFeaturedGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if !s.isModerator(r) {
+		s.renderError(w, r, errForbidden)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	date, err := time.Parse(time.DateOnly, r.PostForm.Get("date"))
+	if err != nil {
+		s.renderError(w, r, badRequest("Choose a valid date to feature this garbage on.", err))
+		return
+	}
+
+	// drafts and scheduled garbage can't be featured, since nobody else can see them yet
+	err = s.store.QueryRow(r.Context(), "SELECT id FROM garbages WHERE id = $1 AND published_at IS NOT NULL", garbageID).
+		Scan(&garbageID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.renderError(w, r, notFound("That garbage doesn't exist or hasn't been published.", err))
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	_, err = s.store.Exec(r.Context(),
+		`INSERT INTO featured(date, garbage_id, pinned_by) VALUES ($1, $2, $3)
+		ON CONFLICT (date) DO UPDATE SET garbage_id = excluded.garbage_id, pinned_by = excluded.pinned_by`,
+		date,
+		garbageID,
+		userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
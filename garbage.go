@@ -0,0 +1,857 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acaloiaro/garbage_speak/events"
+	"github.com/acaloiaro/garbage_speak/html_parser"
+	"github.com/acaloiaro/garbage_speak/link_preview"
+	"github.com/acaloiaro/neoq/backends/postgres"
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Garbage represents 'garbage' records from the database
+type Garbage struct {
+	ID              uuid.UUID
+	OwnerID         uuid.UUID
+	Username        string
+	Title           string
+	Content         string  // the raw, user-supplied content
+	RenderedContent *string // the content run through goldmark
+	RenderVersion   int     // the version of the rendering pipeline that rendered RenderedContent
+	Metadata        map[string]any
+	Url             string
+	LinkPreview     *link_preview.Preview // OpenGraph metadata fetched from Url
+	CreatedAt       time.Time
+	N               int
+	UplevelCount    int            // the number of users who upleveled the garbage
+	Upleveled       bool           // whether the current user upleveled the garbage
+	PublishedAt     *time.Time     // when the garbage was published, nil for drafts and scheduled garbage
+	PublishAt       *time.Time     // when scheduled garbage is to be published
+	Bookmarked      bool           // whether the current user bookmarked the garbage
+	Following       bool           // whether the current user follows the garbage's owner
+	ReactionCounts  map[string]int // the number of users who reacted to the garbage with each type of reaction
+	Reactions       []string       // the types of reaction the current user reacted to the garbage with
+}
+
+// RenderedHTML returns the garbage's rendered content as HTML that templates may include without escaping. Content
+// rendered by the current rendering pipeline was sanitized before it was stored, see markdownRenderer. Content rendered
+// by an older pipeline may not have been, so it's sanitized here until the rerender_garbage job re-renders it.
+func (g Garbage) RenderedHTML() template.HTML {
+	if g.RenderedContent == nil {
+		return ""
+	}
+
+	if g.RenderVersion < renderVersion {
+		return template.HTML(sanitizePolicy.Sanitize(*g.RenderedContent))
+	}
+
+	return template.HTML(*g.RenderedContent)
+}
+
+func (s *Server) editGarbageUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	title := r.PostForm.Get("title")
+	content := r.PostForm.Get("garbage")
+	// TODO this is an arbitrary length that will likely need to change
+	if len(content) == 10 {
+		s.renderError(w, r, badRequest("That garbage is too short.", nil))
+		return
+	}
+
+	url, err := normalizeFormURL(r.PostForm.Get("url"))
+	if err != nil {
+		formError(w, "url-error", "Please enter a valid http or https URL.")
+		return
+	}
+
+	title, content, ok := s.redactGarbage(w, r, title, content)
+	if !ok {
+		return
+	}
+
+	// only unpublished garbage may change its publication; published garbage can't be taken back
+	changesPublication := r.PostForm.Has("visibility")
+	var publishedAt, publishAt *time.Time
+	if changesPublication {
+		publishedAt, publishAt, err = publication(r)
+		if err != nil {
+			formError(w, "publish-at-error", "Please choose a time in the future to publish at.")
+			return
+		}
+	}
+
+	renderedContent := s.renderer.Render(content)
+	metadata := map[string]any{}
+	tags := r.Form["tags"]
+	if len(tags) > 0 {
+		metadata["tags"] = tags
+	}
+
+	// publicationChanged is whether the garbage was unpublished, and its publication was changed
+	publicationChanged := false
+	// urlChanged is whether the garbage's URL was changed, so that its link preview is out of date
+	urlChanged := false
+	ctx := context.WithoutCancel(r.Context())
+	err = pgx.BeginFunc(ctx, s.store, func(tx pgx.Tx) (err error) {
+		var previousURL string
+		err = tx.QueryRow(ctx, "SELECT url FROM garbages WHERE id = $1 AND owner_id = $2 FOR UPDATE", garbageID, userID).
+			Scan(&previousURL)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return notFound("That garbage doesn't exist.", err)
+		}
+		if err != nil {
+			return
+		}
+		urlChanged = url != previousURL
+
+		// link previews are only fetched again when the URL changes, so they're kept for other edits
+		_, err = tx.Exec(ctx,
+			`UPDATE garbages SET (title, content, rendered_content, render_version, url, metadata) = ($1, $2, $3, $4, $5, $6),
+				link_preview = CASE WHEN $7 THEN NULL ELSE link_preview END
+			WHERE id = $8`,
+			title,
+			content,
+			renderedContent,
+			renderVersion,
+			url,
+			metadata,
+			urlChanged,
+			garbageID)
+		if err != nil {
+			return
+		}
+
+		if !changesPublication {
+			return
+		}
+
+		// garbage is ordered by n, so garbage gets a new n when it's published to appear as the newest garbage
+		tag, err := tx.Exec(ctx,
+			`UPDATE garbages SET
+				published_at = $1,
+				publish_at = $2,
+				n = CASE WHEN $1::timestamptz IS NULL THEN n ELSE nextval(pg_get_serial_sequence('garbages', 'n')) END
+			WHERE id = $3 AND owner_id = $4 AND published_at IS NULL`,
+			publishedAt,
+			publishAt,
+			garbageID,
+			userID)
+		publicationChanged = err == nil && tag.RowsAffected() > 0
+		return
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if urlChanged {
+		s.enqueueLinkPreview(ctx, garbageID, url)
+	}
+
+	if publicationChanged {
+		if publishedAt == nil {
+			s.schedulePublication(ctx, garbageID, publishAt)
+			w.Header().Add("hx-location", fmt.Sprintf("%s/garbage/drafts", s.config.APIURL()))
+			return
+		}
+
+		s.garbagePublished(ctx, garbageID)
+	}
+
+	w.Header().Add("hx-location", s.config.AppURL())
+}
+
+func (s *Server) editGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	// garbage may only be edited by its owner; other users' garbage doesn't exist as far as they're concerned
+	garbage := Garbage{}
+	ctx := context.WithoutCancel(r.Context())
+	err := pgxscan.Get(
+		ctx,
+		s.store,
+		&garbage,
+		`SELECT id, owner_id, title, content, rendered_content, render_version, metadata, url, published_at, publish_at
+		FROM garbages WHERE id = $1 AND owner_id = $2`, garbageID, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.renderError(w, r, notFound("That garbage doesn't exist.", err))
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	availableTags := []string{
+		"Nouned verb",
+		"Verbed noun",
+		"Nouned adjective",
+		"Novel garbage",
+		"Standard-issue garbage",
+	}
+	selectedTags := map[string]bool{}
+	if tags, ok := garbage.Metadata["tags"].([]interface{}); ok {
+		for _, tag := range tags {
+			selectedTags[tag.(string)] = true
+		}
+	}
+
+	tmplVars := map[string]any{
+		"ApiBaseUrl":    s.config.APIURL(),
+		"Garbage":       garbage,
+		"SelectedTags":  selectedTags,
+		"AvailableTags": availableTags,
+	}
+	err = s.writeTemplate(r.Context(), w, "garbage", "edit.html", tmplVars)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// rerenderGarbageHandler re-renders and sanitizes all garbage rendered by an older version of the rendering pipeline
+func (s *Server) rerenderGarbageHandler(ctx context.Context) (err error) {
+	garbage := []*Garbage{}
+	err = pgxscan.Select(ctx, s.store, &garbage, "SELECT id, content FROM garbages WHERE render_version < $1", renderVersion)
+	if err != nil {
+		return
+	}
+
+	for _, g := range garbage {
+		_, err = s.store.Exec(ctx,
+			"UPDATE garbages SET (rendered_content, render_version) = ($1, $2) WHERE id = $3",
+			s.renderer.Render(g.Content),
+			renderVersion,
+			g.ID)
+		if err != nil {
+			return
+		}
+	}
+
+	slog.InfoContext(ctx, "rerendered garbage", "count", len(garbage), "render_version", renderVersion)
+
+	return
+}
+
+// previewGarbageHandler renders the submitted markdown with the same configuration used when garbage is saved, so that
+// forms can show users what their garbage will look like before it's posted
+func (s *Server) previewGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	renderedContent := s.renderer.Render(r.PostForm.Get("garbage"))
+
+	w.Header().Add("Content-Type", "text/html")
+	w.Write([]byte(renderedContent))
+}
+
+func (s *Server) createGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	if !isLoggedIn(r) {
+		w.Header().Add("hx-location", fmt.Sprintf("%s/users/login", s.config.AppURL()))
+		return
+	}
+
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	title := r.PostForm.Get("title")
+	content := r.PostForm.Get("garbage")
+	// TODO this is an arbitrary length that will likely need to change
+	if len(content) == 10 {
+		s.renderError(w, r, badRequest("That garbage is too short.", nil))
+		return
+	}
+
+	url, err := normalizeFormURL(r.PostForm.Get("url"))
+	if err != nil {
+		formError(w, "url-error", "Please enter a valid http or https URL.")
+		return
+	}
+
+	title, content, ok := s.redactGarbage(w, r, title, content)
+	if !ok {
+		return
+	}
+
+	publishedAt, publishAt, err := publication(r)
+	if err != nil {
+		formError(w, "publish-at-error", "Please choose a time in the future to publish at.")
+		return
+	}
+
+	renderedContent := s.renderer.Render(content)
+
+	metadata := map[string]any{}
+	tags := r.Form["tags"]
+	if len(tags) > 0 {
+		metadata["tags"] = tags
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+
+	// submitters are asked to confirm that their garbage is not a duplicate before it's posted. Confirmations only hold
+	// for what was checked, so garbage that changes after it's confirmed is checked again.
+	checked := confirmationToken(title, content, url)
+	if r.PostForm.Get("confirmed") != checked {
+		duplicates := []*Garbage{}
+		err = pgxscan.Select(ctx, s.store, &duplicates,
+			`SELECT id, title FROM garbages
+			WHERE ((normalized_content % normalize_garbage($1) AND similarity(normalized_content, normalize_garbage($1)) >= $2)
+			OR (url <> '' AND url = $3))
+			AND (published_at IS NOT NULL OR owner_id = $4)
+			ORDER BY similarity(normalized_content, normalize_garbage($1)) DESC
+			LIMIT 5`,
+			content,
+			duplicateSimilarity,
+			url,
+			userID)
+		if err != nil {
+			s.renderError(w, r, err)
+			return
+		}
+
+		if len(duplicates) > 0 {
+			w.Header().Add("HX-Retarget", "#duplicates")
+			w.Header().Add("HX-Reswap", "innerHTML")
+			err = s.writeTemplate(r.Context(), w, "garbage", "duplicates.html", map[string]any{
+				"Duplicates":   duplicates,
+				"ApiBaseUrl":   s.config.APIURL(),
+				"Confirmation": checked,
+			})
+			if err != nil {
+				s.renderError(w, r, err)
+			}
+			return
+		}
+	}
+
+	var garbageID string
+	err = s.store.QueryRow(ctx,
+		`INSERT INTO garbages(title, content, rendered_content, render_version, url, metadata, owner_id, published_at, publish_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+		title,
+		content,
+		renderedContent,
+		renderVersion,
+		url,
+		metadata,
+		userID,
+		publishedAt,
+		publishAt).Scan(&garbageID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.enqueueLinkPreview(ctx, garbageID, url)
+
+	if publishedAt == nil {
+		s.schedulePublication(ctx, garbageID, publishAt)
+		w.Header().Add("hx-location", fmt.Sprintf("%s/garbage/drafts", s.config.APIURL()))
+		return
+	}
+
+	s.garbagePublished(ctx, garbageID)
+
+	w.Header().Add("hx-location", s.config.AppURL())
+}
+
+// confirmationToken identifies the submitted fields that a user was asked to confirm something about, so that the
+// confirmation can be tied to exactly what they saw
+func confirmationToken(fields ...string) string {
+	hash := sha256.New()
+	for _, field := range fields {
+		// fields are length-prefixed, so that moving text between fields changes the token
+		fmt.Fprintf(hash, "%d:%s", len(field), field)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(hash.Sum(nil))
+}
+
+// publication returns when garbage submitted by r is to be published. Garbage published immediately has a publishedAt,
+// scheduled garbage has a publishAt, and drafts have neither.
+func publication(r *http.Request) (publishedAt, publishAt *time.Time, err error) {
+	switch r.PostForm.Get("visibility") {
+	case "draft":
+		return
+	case "schedule":
+		// datetime-local inputs have no time zone; times are entered in UTC
+		var t time.Time
+		t, err = time.ParseInLocation("2006-01-02T15:04", r.PostForm.Get("publish_at"), time.UTC)
+		if err != nil {
+			return
+		}
+
+		if !t.After(time.Now()) {
+			err = errors.New("garbage may only be scheduled to be published in the future")
+			return
+		}
+
+		publishAt = &t
+	default:
+		now := time.Now()
+		publishedAt = &now
+	}
+
+	return
+}
+
+// schedulePublication queues scheduled garbage to be published at publishAt. It does nothing for drafts.
+func (s *Server) schedulePublication(ctx context.Context, garbageID string, publishAt *time.Time) {
+	if publishAt == nil {
+		return
+	}
+
+	err := s.enqueue(ctx, &jobs.Job{
+		Queue: "publish_garbage",
+		Payload: map[string]any{
+			"garbage_id": garbageID,
+			"publish_at": publishAt.Format(time.RFC3339),
+		},
+		RunAfter: *publishAt,
+	})
+	if err != nil && !errors.Is(err, postgres.ErrDuplicateJob) {
+		slog.ErrorContext(ctx, "unable to queue garbage publication", "garbage_id", garbageID, "error", err)
+	}
+}
+
+// publishGarbageHandler publishes scheduled garbage
+func (s *Server) publishGarbageHandler(ctx context.Context) (err error) {
+	var j *jobs.Job
+	j, err = jobs.FromContext(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to publish garbage", "error", err)
+		return
+	}
+
+	publishAt, err := time.Parse(time.RFC3339, j.Payload["publish_at"].(string))
+	if err != nil {
+		return
+	}
+
+	garbageID := j.Payload["garbage_id"].(string)
+
+	// garbage that was rescheduled, or turned back into a draft, after this job was queued is left alone
+	tag, err := s.store.Exec(ctx,
+		`UPDATE garbages SET published_at = now(), n = nextval(pg_get_serial_sequence('garbages', 'n'))
+		WHERE id = $1 AND published_at IS NULL AND publish_at = $2`,
+		garbageID,
+		publishAt)
+	if err != nil {
+		return
+	}
+
+	if tag.RowsAffected() > 0 {
+		s.garbagePublished(ctx, garbageID)
+	}
+
+	return
+}
+
+// garbagePublished is called whenever garbage is published, whether immediately or on schedule
+func (s *Server) garbagePublished(ctx context.Context, garbageID string) {
+	s.metrics.Posted()
+	s.enqueueFollowerNotifications(ctx, garbageID)
+}
+
+// redactGarbage replaces identifying information in garbage titles and content with placeholders. When anything is
+// redacted, submitters are shown what was redacted and must confirm the redactions before their garbage is saved.
+// Confirmations only hold for the redacted text that was shown, so garbage whose redactions change is shown again. ok
+// is false when the redactions were rendered for confirmation, and the garbage should not be saved.
+func (s *Server) redactGarbage(w http.ResponseWriter, r *http.Request, title, content string) (redactedTitle, redactedContent string, ok bool) {
+	titleResult := s.redactor.Redact(title)
+	contentResult := s.redactor.Redact(content)
+	redactedTitle = titleResult.Content
+	redactedContent = contentResult.Content
+
+	shown := confirmationToken(redactedTitle, redactedContent)
+	if (!titleResult.Redacted() && !contentResult.Redacted()) || r.PostForm.Get("redactions_confirmed") == shown {
+		ok = true
+		return
+	}
+
+	w.Header().Add("HX-Retarget", "#redactions")
+	w.Header().Add("HX-Reswap", "innerHTML")
+	err := s.writeTemplate(r.Context(), w, "garbage", "redactions.html", map[string]any{
+		"Title":        titleResult,
+		"Content":      contentResult,
+		"Confirmation": shown,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+	}
+
+	return
+}
+
+// normalizeFormURL normalizes the optional "where seen" URL submitted with garbage. Empty URLs are permitted
+func normalizeFormURL(rawURL string) (url string, err error) {
+	if strings.TrimSpace(rawURL) == "" {
+		return
+	}
+
+	return link_preview.NormalizeURL(rawURL)
+}
+
+// formError renders message into the element with the given id, rather than the request's original target, so that
+// forms can display validation errors without being replaced
+func formError(w http.ResponseWriter, id, message string) {
+	w.Header().Add("HX-Retarget", fmt.Sprintf("#%s", id))
+	w.Header().Add("HX-Reswap", "innerHTML")
+	w.Header().Add("Content-Type", "text/html")
+	w.Write([]byte(template.HTMLEscapeString(message)))
+}
+
+// enqueueLinkPreview queues a job to fetch the link preview for garbage seen at url
+func (s *Server) enqueueLinkPreview(ctx context.Context, garbageID, url string) {
+	if url == "" {
+		return
+	}
+
+	err := s.enqueue(ctx, &jobs.Job{
+		Queue: "link_preview",
+		Payload: map[string]any{
+			"garbage_id": garbageID,
+			"url":        url,
+		},
+	})
+	if err != nil && !errors.Is(err, postgres.ErrDuplicateJob) {
+		slog.ErrorContext(ctx, "unable to queue link preview", "garbage_id", garbageID, "error", err)
+	}
+}
+
+// linkPreviewHandler fetches the OpenGraph metadata of the URL where garbage was seen, to be displayed as a preview card
+func (s *Server) linkPreviewHandler(ctx context.Context) (err error) {
+	var j *jobs.Job
+	j, err = jobs.FromContext(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to process link preview", "error", err)
+		return
+	}
+	garbageID := j.Payload["garbage_id"].(string)
+	url := j.Payload["url"].(string)
+
+	preview, err := s.linkFetcher.Fetch(ctx, url)
+	if err != nil {
+		return
+	}
+
+	// the url may have been edited since this job was queued, in which case a newer job fetches its preview
+	_, err = s.store.Exec(ctx, "UPDATE garbages SET link_preview = $1 WHERE id = $2 AND url = $3", preview, garbageID, url)
+
+	return
+}
+
+// pagedQuery returns a paged query for the given query, along with the paged query's arguments. The given query must
+// select garbages' n column, and may refer to its own arguments as $1 through $len(args)
+func pagedQuery(r *http.Request, query string, args ...any) (pagedQuery string, pagedArgs []any) {
+	pagedArgs = append(args, pageSize)
+
+	// the query is paged as a subquery so that it may have WHERE clauses of its own
+	pagedQuery = fmt.Sprintf("SELECT * FROM (%s) AS page", query)
+
+	firstItem, err := strconv.Atoi(r.URL.Query().Get("first_item"))
+	if err == nil {
+		// newer items have a larger n, since n monotonically increases
+		// hence we filter where n < our first item's n
+		pagedArgs = append(pagedArgs, firstItem)
+		pagedQuery = fmt.Sprintf("%s WHERE n < $%d", pagedQuery, len(pagedArgs))
+	}
+
+	pagedQuery = fmt.Sprintf("%s ORDER BY n DESC LIMIT $%d", pagedQuery, len(args)+1)
+
+	return
+}
+
+// listGarbageHandler returns the latest garbage
+func (s *Server) listGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	query := `SELECT
+			garbages.id, n, owner_id, username, title, rendered_content, render_version, metadata, url, link_preview, uplevel_count,
+			` + upleveledColumn + `, ` + bookmarkedColumn + `, ` + followingColumn + `,
+			reaction_counts, ` + reactionsColumn + `, garbages.created_at, published_at, publish_at
+			FROM garbages
+			JOIN users ON garbages.owner_id = users.id
+			WHERE published_at IS NOT NULL`
+
+	// the following feed contains only garbage posted by users the current user follows
+	following := r.URL.Query().Get("feed") == "following"
+	if following {
+		if userID == "" {
+			w.Header().Add("hx-location", fmt.Sprintf("%s/users/login", s.config.AppURL()))
+			return
+		}
+
+		query += " AND owner_id IN (SELECT followee_id FROM follows WHERE follower_id = NULLIF($1, '')::uuid)"
+	}
+	pagedQuery, args := pagedQuery(r, query, userID)
+
+	ctx := context.WithoutCancel(r.Context())
+	garbage := []*Garbage{}
+	err := pgxscan.Select(ctx, s.store, &garbage, pagedQuery, args...)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// pagination
+	var lastItem *int
+	il := len(garbage) - 1
+	if il >= 0 {
+		lastItem = &(garbage[il].N)
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "garbage", "list.html", map[string]any{
+		"Posts":         garbage,
+		"ApiBaseUrl":    s.config.APIURL(),
+		"LoggedIn":      isLoggedIn(r),
+		"UserID":        userID,
+		"IsModerator":   s.isModerator(r),
+		"NextPage":      nextPageURL(r, fmt.Sprintf("%s/garbage/list", s.config.APIURL()), lastItem),
+		"Following":     following,
+		"ShowFeeds":     userID != "",
+		"ReactionTypes": s.reactionTypes,
+		// only the first page of the global feed receives newly created garbage
+		"Live": r.URL.Query().Get("first_item") == "" && !following,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// listDraftsHandler returns the current user's drafts and scheduled garbage
+func (s *Server) listDraftsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		w.Header().Add("hx-location", fmt.Sprintf("%s/users/login", s.config.AppURL()))
+		return
+	}
+
+	query := `SELECT
+			garbages.id, n, owner_id, username, title, rendered_content, render_version, metadata, url, link_preview, uplevel_count,
+			` + upleveledColumn + `, ` + bookmarkedColumn + `, ` + followingColumn + `,
+			reaction_counts, ` + reactionsColumn + `, garbages.created_at, published_at, publish_at
+			FROM garbages
+			JOIN users ON garbages.owner_id = users.id
+			WHERE owner_id = $1 AND published_at IS NULL`
+	pagedQuery, args := pagedQuery(r, query, userID)
+
+	garbage := []*Garbage{}
+	err := pgxscan.Select(r.Context(), s.store, &garbage, pagedQuery, args...)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	var lastItem *int
+	if len(garbage) > 0 {
+		lastItem = &(garbage[len(garbage)-1].N)
+	}
+
+	buff := bytes.NewBufferString("<h2>My drafts</h2>")
+	err = s.templates.Render(r.Context(), buff, "garbage", "list.html", map[string]any{
+		"Posts":         garbage,
+		"ApiBaseUrl":    s.config.APIURL(),
+		"LoggedIn":      true,
+		"UserID":        userID,
+		"NextPage":      nextPageURL(r, fmt.Sprintf("%s/garbage/drafts", s.config.APIURL()), lastItem),
+		"ReactionTypes": s.reactionTypes,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// nextPageURL returns the URL of the page of garbage following the page requested by r, whose last item is lastItem.
+// The request's other query parameters, e.g. feed, carry over to the next page. It returns an empty string when there
+// is no next page.
+func nextPageURL(r *http.Request, pageURL string, lastItem *int) string {
+	if lastItem == nil {
+		return ""
+	}
+
+	query := r.URL.Query()
+	query.Set("first_item", strconv.Itoa(*lastItem))
+
+	return fmt.Sprintf("%s?%s", pageURL, query.Encode())
+}
+
+// showGarbageHandler returns the latest garbage
+func (s *Server) showGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	ctx := context.WithoutCancel(r.Context())
+	garbage := Garbage{}
+	err := pgxscan.Get(
+		ctx,
+		s.store,
+		&garbage,
+		`SELECT garbages.id, owner_id, username, title, rendered_content, render_version, metadata, url, link_preview, uplevel_count,
+			`+upleveledColumn+`, `+bookmarkedColumn+`, `+followingColumn+`,
+			reaction_counts, `+reactionsColumn+`, garbages.created_at, published_at, publish_at
+			FROM garbages
+			JOIN users ON garbages.owner_id = users.id
+			WHERE garbages.id = $2 AND (published_at IS NOT NULL OR owner_id = NULLIF($1, '')::uuid)`, userID, garbageID)
+	if err != nil {
+		// garbage that was merged into another post redirects to the post it was merged into
+		var survivorID string
+		if s.store.QueryRow(ctx, "SELECT merged_into FROM garbage_merges WHERE garbage_id = $1", garbageID).Scan(&survivorID) == nil {
+			http.Redirect(w, r, fmt.Sprintf("%s/garbage/%s", s.config.APIURL(), survivorID), http.StatusMovedPermanently)
+			return
+		}
+
+		if toAppError(err).Status == http.StatusNotFound {
+			err = notFound("That garbage doesn't exist, or it was deleted.", err)
+		}
+
+		s.renderError(w, r, err)
+		return
+	}
+
+	// uplevel counts update live. Garbage fetched into a live feed uses the feed's connection, and permalinks their own
+	liveFeed := r.URL.Query().Get("live_feed") == "true"
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "garbage", "show.tmpl", map[string]any{
+		"Garbage":       garbage,
+		"ApiBaseUrl":    s.config.APIURL(),
+		"LoggedIn":      isLoggedIn(r),
+		"UserID":        userID,
+		"IsModerator":   s.isModerator(r),
+		"ReactionTypes": s.reactionTypes,
+		"Live":          true,
+		"ConnectEvents": !liveFeed,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePageWithHead(w, r, buff, garbage.Title, s.garbageMeta(ctx, garbage)...)
+}
+
+// garbageMeta returns the meta tags that describe garbage's permalink to search engines and link previews
+func (s *Server) garbageMeta(ctx context.Context, garbage Garbage) []html_parser.Meta {
+	description := ""
+	if garbage.RenderedContent != nil {
+		text, err := html_parser.Text(*garbage.RenderedContent)
+		if err != nil {
+			slog.WarnContext(ctx, "unable to describe garbage", "garbage_id", garbage.ID, "error", err)
+		}
+		description = text
+	}
+	if description == "" && garbage.LinkPreview != nil {
+		description = garbage.LinkPreview.Description
+	}
+	description = truncate(description, descriptionLength)
+
+	return []html_parser.Meta{
+		{Name: "description", Content: description},
+		{Property: "og:type", Content: "article"},
+		{Property: "og:title", Content: garbage.Title},
+		{Property: "og:description", Content: description},
+		{Property: "og:url", Content: fmt.Sprintf("%s/garbage/%s", s.config.APIURL(), garbage.ID)},
+	}
+}
+
+// truncate shortens text to at most n characters, ending it with an ellipsis at a word boundary if it's shortened
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	truncated := string(runes[:n-1])
+	if i := strings.LastIndex(truncated, " "); i > 0 {
+		truncated = truncated[:i]
+	}
+
+	return strings.TrimRight(truncated, " .,;:") + "…"
+}
+
+// garbageEvent converts 'garbage_events' notifications into server-sent events
+func (s *Server) garbageEvent(payload string) (event events.Event, err error) {
+	var notification struct {
+		Type         string `json:"type"`
+		GarbageID    string `json:"garbage_id"`
+		UplevelCount int    `json:"uplevel_count"`
+	}
+	err = json.Unmarshal([]byte(payload), &notification)
+	if err != nil {
+		return
+	}
+
+	switch notification.Type {
+	case "garbage_created":
+		// garbage is rendered differently for each viewer, e.g. owners see an edit link, so rather than rendering new
+		// garbage once for everyone, clients fetch new garbage for themselves
+		event = events.Event{
+			Name: "garbage_created",
+			Data: fmt.Sprintf(`<div hx-get="%s/garbage/%s?live_feed=true" hx-trigger="load" hx-swap="outerHTML"></div>`,
+				s.config.APIURL(),
+				template.HTMLEscapeString(notification.GarbageID)),
+		}
+	case "uplevel_count":
+		event = events.Event{
+			Name: fmt.Sprintf("uplevel_count:%s", notification.GarbageID),
+			Data: strconv.Itoa(notification.UplevelCount),
+		}
+	default:
+		err = fmt.Errorf("unknown garbage event type: %s", notification.Type)
+	}
+
+	return
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// healthzHandler reports that the server process is alive. It checks nothing else, so that the process isn't restarted
+// for problems that restarting won't fix, such as Postgres being unavailable
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
+// readyzHandler reports whether the server is ready to serve requests: Postgres is reachable, the database has been
+// migrated to the newest migration, and background jobs have started. Every check is reported, even when earlier ones
+// fail, and the response is 503 Service Unavailable unless all of them pass.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]string{
+		"postgres":   "ok",
+		"migrations": "ok",
+		"jobs":       "ok",
+	}
+
+	var one int
+	err := s.store.QueryRow(ctx, "SELECT 1").Scan(&one)
+	if err != nil {
+		slog.WarnContext(ctx, "readiness: postgres is unreachable", "error", err)
+		checks["postgres"] = "unreachable"
+		checks["migrations"] = "unknown"
+	} else {
+		checks["migrations"] = s.migrationStatus(ctx)
+	}
+
+	if !s.jobsStarted.Load() {
+		checks["jobs"] = "not started"
+	}
+
+	status := http.StatusOK
+	for _, result := range checks {
+		if result != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"ready":  status == http.StatusOK,
+		"checks": checks,
+	})
+}
+
+// migrationStatus returns "ok" if the database has been migrated to the newest migration, or what's wrong otherwise
+func (s *Server) migrationStatus(ctx context.Context) string {
+	expected, err := latestMigration()
+	if err != nil {
+		slog.WarnContext(ctx, "readiness: unable to find the newest migration", "error", err)
+		return "unknown"
+	}
+
+	var version uint64
+	var dirty bool
+	err = s.store.QueryRow(ctx, "SELECT version, dirty FROM schema_migrations").Scan(&version, &dirty)
+	switch {
+	case err != nil:
+		slog.WarnContext(ctx, "readiness: unable to read migration version", "error", err)
+		return "unknown"
+	case dirty:
+		return fmt.Sprintf("version %d failed to apply", version)
+	case version != expected:
+		return fmt.Sprintf("at version %d, expected %d", version, expected)
+	}
+
+	return "ok"
+}
+
+// versionHandler reports the build's commit and time. Builds without -ldflags fall back on the version control
+// information that go build embeds, when there is any
+func (s *Server) versionHandler(w http.ResponseWriter, r *http.Request) {
+	version := map[string]string{
+		"commit":     commit,
+		"build_time": buildTime,
+		"go_version": runtime.Version(),
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && version["commit"] == "":
+				version["commit"] = setting.Value
+			case setting.Key == "vcs.time" && version["build_time"] == "":
+				version["build_time"] = setting.Value
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
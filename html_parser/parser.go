@@ -1,12 +1,14 @@
 package html_parser
 
 import (
-	"bytes"
+	"errors"
+	"fmt"
 	"io"
-	"log"
+	"sort"
 	"strings"
 
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 func htmlGetAttr(n *html.Node, key string) (string, bool) {
@@ -18,28 +20,6 @@ func htmlGetAttr(n *html.Node, key string) (string, bool) {
 	return "", false
 }
 
-// htmlRenderNode renders a node, excluding its children
-func htmlRenderNode(n *html.Node) string {
-	var buf bytes.Buffer
-	w := io.Writer(&buf)
-
-	// walk backwards through the document tree to find the root
-	for {
-		if n.Parent != nil {
-			n = n.Parent
-			continue
-		}
-		break
-	}
-
-	err := html.Render(w, n)
-	if err != nil {
-		return ""
-	}
-
-	return buf.String()
-}
-
 func htmlHasID(n *html.Node, id string) bool {
 	if n.Type == html.ElementNode {
 		s, ok := htmlGetAttr(n, "id")
@@ -70,54 +50,238 @@ func htmlGetByID(n *html.Node, id string) *html.Node {
 	return htmlWalkTree(n, id)
 }
 
-// ParseAndSplice parses HTML within 'file' and splices `htmlContent` into its node tree at `id`
-//
-// Returns rendered HTML content as a string
-func ParseAndSplice(file io.Reader, id, htmlContent string) (content string) {
+// htmlGetByAtom returns the first element of the given type, e.g. atom.Head, within n
+func htmlGetByAtom(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		res := htmlGetByAtom(c, a)
+		if res != nil {
+			return res
+		}
+	}
+
+	return nil
+}
+
+// htmlSetAttr sets the value of n's attribute, adding the attribute if n doesn't have it
+func htmlSetAttr(n *html.Node, key, val string) {
+	for i, attr := range n.Attr {
+		if attr.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// htmlText returns the text content of n and its descendants
+func htmlText(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	return b.String()
+}
+
+// Meta is a <meta> tag, identified by either its name, e.g. description, or its property, e.g. og:title
+type Meta struct {
+	Name     string
+	Property string
+	Content  string
+}
+
+// Page is what's spliced into a layout to make a page
+type Page struct {
+	// Regions maps element IDs to the HTML that becomes each element's only content
+	Regions map[string]string
+
+	// Title replaces the layout's <title>, unless it's empty
+	Title string
+
+	// Meta are added to the layout's <head>, replacing any tags that have the same name or property
+	Meta []Meta
+}
+
+// Layout is a parsed HTML document into which pages are spliced. Layouts are parsed once, and may be rendered any
+// number of times, concurrently.
+type Layout struct {
+	doc *html.Node
+}
+
+// ParseLayout parses the HTML document within 'file'
+func ParseLayout(file io.Reader) (layout *Layout, err error) {
 	doc, err := html.Parse(file)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
 
-	doc = htmlGetByID(doc, id)
-	if doc == nil {
-		return
+	return &Layout{doc: doc}, nil
+}
+
+// Title returns the text of the layout's <title>
+func (l *Layout) Title() string {
+	title := htmlGetByAtom(l.doc, atom.Title)
+	if title == nil {
+		return ""
 	}
 
-	// Get rid of the element's children, as we want to swap our own content in as this element's _only_ content
-	doc.FirstChild = nil
-	doc.LastChild = nil
-
-	// Parse the content we're swapping in
-	// This creates a "well-formed" tree, meaning that it adds <html><head></head><body><our node/></body></html>
-	// but we don't want a well-formed tree, we want our new content as a single Node. The tree parents will be
-	// removed
-	newHtml, err := html.Parse(strings.NewReader(htmlContent))
-	if err != nil {
-		log.Fatal(err)
+	return htmlText(title)
+}
+
+// Render splices page into a copy of the layout's node tree and writes the resulting HTML to w. The layout itself is
+// left unchanged.
+func (l *Layout) Render(w io.Writer, page Page) error {
+	return render(w, cloneNode(l.doc), page)
+}
+
+// cloneNode returns a deep copy of n and its descendants
+func cloneNode(n *html.Node) *html.Node {
+	clone := &html.Node{
+		Type:      n.Type,
+		DataAtom:  n.DataAtom,
+		Data:      n.Data,
+		Namespace: n.Namespace,
+		Attr:      append([]html.Attribute{}, n.Attr...),
+	}
+
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		clone.AppendChild(cloneNode(c))
+	}
+
+	return clone
+}
+
+// render splices page into doc's node tree, modifying doc, and writes the resulting HTML to w
+func render(w io.Writer, doc *html.Node, page Page) (err error) {
+	// regions are spliced in a consistent order, in case one region's content contains another region
+	ids := make([]string, 0, len(page.Regions))
+	for id := range page.Regions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		err = splice(doc, id, page.Regions[id])
+		if err != nil {
+			return
+		}
 	}
 
-	// Remove the tree parents, as we do not want a "well-formed" tree
-	for {
-		if newHtml.LastChild == nil {
-			break
+	if page.Title != "" || len(page.Meta) > 0 {
+		head := htmlGetByAtom(doc, atom.Head)
+		if head == nil {
+			return errors.New("document has no <head>")
+		}
+
+		if page.Title != "" {
+			setTitle(head, page.Title)
+		}
+
+		for _, meta := range page.Meta {
+			setMeta(head, meta)
 		}
-		newHtml = newHtml.LastChild
 	}
 
-	// We've walked all the way down the new tree to the lowermost node of our new HTML tree.
-	// Get its parent to get the outermost element of the new content (spliced in nodes should
-	// not consist of nested node)
-	newHtml = newHtml.Parent
+	return html.Render(w, doc)
+}
 
-	// "detach" the new node so it can be spliced into the tree
-	newHtml.Parent = nil
-	newHtml.PrevSibling = nil
-	newHtml.NextSibling = nil
+// splice replaces the children of the element with the given id with `htmlContent`
+func splice(doc *html.Node, id, htmlContent string) error {
+	el := htmlGetByID(doc, id)
+	if el == nil {
+		return fmt.Errorf("document has no element with id '%s'", id)
+	}
 
-	doc.AppendChild(newHtml)
+	// Parse the content as the element's children, rather than as a document of its own, which would wrap it in
+	// <html><head></head><body></body></html>
+	nodes, err := html.ParseFragment(strings.NewReader(htmlContent), el)
+	if err != nil {
+		return fmt.Errorf("unable to parse content for '%s': %w", id, err)
+	}
+
+	// Get rid of the element's children, as we want to swap our own content in as this element's _only_ content
+	for el.FirstChild != nil {
+		el.RemoveChild(el.FirstChild)
+	}
 
-	content = htmlRenderNode(doc)
+	for _, n := range nodes {
+		el.AppendChild(n)
+	}
+
+	return nil
+}
+
+// setTitle sets the text of head's <title>, adding a <title> if head doesn't have one
+func setTitle(head *html.Node, title string) {
+	el := htmlGetByAtom(head, atom.Title)
+	if el == nil {
+		el = &html.Node{Type: html.ElementNode, DataAtom: atom.Title, Data: "title"}
+		head.AppendChild(el)
+	}
+
+	for el.FirstChild != nil {
+		el.RemoveChild(el.FirstChild)
+	}
+
+	el.AppendChild(&html.Node{Type: html.TextNode, Data: title})
+}
+
+// setMeta sets the content of head's <meta> tag with meta's name or property, adding the tag if head doesn't have it
+func setMeta(head *html.Node, meta Meta) {
+	key, val := "name", meta.Name
+	if meta.Property != "" {
+		key, val = "property", meta.Property
+	}
+
+	for c := head.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || c.DataAtom != atom.Meta {
+			continue
+		}
+
+		if v, ok := htmlGetAttr(c, key); ok && v == val {
+			htmlSetAttr(c, "content", meta.Content)
+			return
+		}
+	}
+
+	head.AppendChild(&html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Meta,
+		Data:     "meta",
+		Attr:     []html.Attribute{{Key: key, Val: val}, {Key: "content", Val: meta.Content}},
+	})
+}
+
+// Text returns the text of the HTML fragment `htmlContent`, without its markup, and with runs of whitespace collapsed
+// into single spaces
+func Text(htmlContent string) (text string, err error) {
+	nodes, err := html.ParseFragment(strings.NewReader(htmlContent), &html.Node{
+		Type:     html.ElementNode,
+		DataAtom: atom.Body,
+		Data:     "body",
+	})
+	if err != nil {
+		return
+	}
+
+	var b strings.Builder
+	for _, n := range nodes {
+		b.WriteString(htmlText(n))
+		b.WriteString(" ")
+	}
 
-	return
+	return strings.Join(strings.Fields(b.String()), " "), nil
 }
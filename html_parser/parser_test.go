@@ -0,0 +1,223 @@
+package html_parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const testLayout = `<!DOCTYPE html>
+<html>
+<head>
+<title>Garbage Speak</title>
+<meta name="description" content="Corporate garbage speak">
+<meta property="og:title" content="Garbage Speak">
+</head>
+<body>
+<nav id="nav"><a href="/">Home</a></nav>
+<main id="main-content"><p>Loading...</p></main>
+</body>
+</html>`
+
+// parseLayout parses document as a layout
+func parseLayout(t *testing.T, document string) *Layout {
+	t.Helper()
+
+	layout, err := ParseLayout(strings.NewReader(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return layout
+}
+
+// renderPage renders page into layout, failing the test if it can't be rendered
+func renderPage(t *testing.T, layout *Layout, page Page) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := layout.Render(&buf, page); err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	return buf.String()
+}
+
+func TestLayoutRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    Page
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "single region",
+			page: Page{Regions: map[string]string{"main-content": "<h1>Synergy</h1>"}},
+			want: []string{
+				`<main id="main-content"><h1>Synergy</h1></main>`,
+				`<nav id="nav"><a href="/">Home</a></nav>`,
+				`<title>Garbage Speak</title>`,
+			},
+			notWant: []string{"Loading..."},
+		},
+		{
+			name: "several regions",
+			page: Page{Regions: map[string]string{
+				"main-content": "<p>Circle back</p>",
+				"nav":          `<a href="/garbage">Garbage</a>`,
+			}},
+			want: []string{
+				`<main id="main-content"><p>Circle back</p></main>`,
+				`<nav id="nav"><a href="/garbage">Garbage</a></nav>`,
+			},
+			notWant: []string{"Loading...", `<a href="/">Home</a>`},
+		},
+		{
+			name: "table rows",
+			page: Page{Regions: map[string]string{"main-content": "<table><tr><td>Boil the ocean</td></tr></table>"}},
+			want: []string{"<td>Boil the ocean</td>"},
+		},
+		{
+			name:    "title",
+			page:    Page{Title: "Synergy | Garbage Speak"},
+			want:    []string{"<title>Synergy | Garbage Speak</title>"},
+			notWant: []string{"<title>Garbage Speak</title>"},
+		},
+		{
+			name: "meta replaces tags with the same name or property",
+			page: Page{Meta: []Meta{
+				{Name: "description", Content: "Let's take this offline"},
+				{Property: "og:title", Content: "Synergy"},
+			}},
+			want: []string{
+				`<meta name="description" content="Let&#39;s take this offline"/>`,
+				`<meta property="og:title" content="Synergy"/>`,
+			},
+			notWant: []string{"Corporate garbage speak", `content="Garbage Speak"`},
+		},
+		{
+			name: "meta the layout doesn't have",
+			page: Page{Meta: []Meta{{Property: "og:image", Content: "https://garbagespeak.example/og.png"}}},
+			want: []string{
+				`<meta property="og:image" content="https://garbagespeak.example/og.png"/>`,
+				`<meta name="description" content="Corporate garbage speak"/>`,
+			},
+		},
+		{
+			name:    "title is escaped",
+			page:    Page{Title: "</title><script>alert(1)</script>"},
+			want:    []string{"<title>&lt;/title&gt;&lt;script&gt;alert(1)&lt;/script&gt;</title>"},
+			notWant: []string{"<script>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renderPage(t, parseLayout(t, testLayout), tt.page)
+
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Render() doesn't contain %q:\n%s", want, got)
+				}
+			}
+
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("Render() contains %q:\n%s", notWant, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLayoutRenderAddsMissingTitle(t *testing.T) {
+	layout := parseLayout(t, `<html><head></head><body id="main-content"></body></html>`)
+
+	if title := layout.Title(); title != "" {
+		t.Errorf("Title() = %q, want no title", title)
+	}
+
+	got := renderPage(t, layout, Page{Title: "Synergy"})
+	if !strings.Contains(got, "<head><title>Synergy</title></head>") {
+		t.Errorf("Render() didn't add a title:\n%s", got)
+	}
+}
+
+func TestLayoutRenderLeavesLayoutUnchanged(t *testing.T) {
+	layout := parseLayout(t, testLayout)
+	want := renderPage(t, layout, Page{})
+
+	renderPage(t, layout, Page{
+		Regions: map[string]string{"main-content": "<p>Synergy</p>"},
+		Title:   "Synergy",
+		Meta:    []Meta{{Name: "description", Content: "Synergy"}, {Name: "author", Content: "Jane"}},
+	})
+
+	if got := renderPage(t, layout, Page{}); got != want {
+		t.Errorf("rendering a page changed the layout:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	if title := layout.Title(); title != "Garbage Speak" {
+		t.Errorf("Title() = %q, want %q", title, "Garbage Speak")
+	}
+}
+
+func TestLayoutRenderErrors(t *testing.T) {
+	// html.Parse adds a <head> to every document, so a layout without one must be built by hand
+	headless := &html.Node{Type: html.DocumentNode}
+	headless.AppendChild(&html.Node{Type: html.ElementNode, DataAtom: atom.Main, Data: "main", Attr: []html.Attribute{{Key: "id", Val: "main-content"}}})
+
+	tests := []struct {
+		name    string
+		layout  *Layout
+		page    Page
+		wantErr string
+	}{
+		{
+			name:    "missing region",
+			layout:  parseLayout(t, testLayout),
+			page:    Page{Regions: map[string]string{"main-content": "<p>Synergy</p>", "sidebar": "<p>Pivot</p>"}},
+			wantErr: "document has no element with id 'sidebar'",
+		},
+		{
+			name:    "title without a head",
+			layout:  &Layout{doc: headless},
+			page:    Page{Regions: map[string]string{"main-content": "<p>Synergy</p>"}, Title: "Synergy"},
+			wantErr: "document has no <head>",
+		},
+		{
+			name:    "meta without a head",
+			layout:  &Layout{doc: headless},
+			page:    Page{Meta: []Meta{{Name: "description", Content: "Synergy"}}},
+			wantErr: "document has no <head>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := tt.layout.Render(&buf, tt.page)
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Render() error = %v, want %q", err, tt.wantErr)
+			}
+
+			if buf.Len() != 0 {
+				t.Errorf("Render() wrote %q despite failing", buf.String())
+			}
+		})
+	}
+}
+
+func TestLayoutRenderWithoutHead(t *testing.T) {
+	// pages without a title or meta don't need the layout to have a <head>
+	layout := &Layout{doc: &html.Node{Type: html.DocumentNode}}
+	layout.doc.AppendChild(&html.Node{Type: html.ElementNode, DataAtom: atom.Main, Data: "main", Attr: []html.Attribute{{Key: "id", Val: "main-content"}}})
+
+	got := renderPage(t, layout, Page{Regions: map[string]string{"main-content": "<p>Synergy</p>"}})
+	if want := `<main id="main-content"><p>Synergy</p></main>`; got != want {
+		t.Errorf("Render() = %q, want %q", got, want)
+	}
+}
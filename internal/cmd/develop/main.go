@@ -66,7 +66,7 @@ func main() {
 		if haveAir {
 			cmd = exec.Command("air")
 		} else {
-			cmd = exec.Command("go", "run", "server.go")
+			cmd = exec.Command("go", "run", ".")
 		}
 		outPipe, _ := cmd.StdoutPipe()
 		errPipe, _ := cmd.StderrPipe()
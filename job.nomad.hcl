@@ -21,6 +21,11 @@ job "garbage_speak" {
       port "garbage_speak" {
         to = 1314
       }
+
+      # metrics are scraped from inside the cluster, and must not be routed to from the internet
+      port "metrics" {
+        to = 1315
+      }
     }
 
     task "db-init" {
@@ -107,6 +112,9 @@ EOF
     task "garbage_speak" {
       driver = "exec"
 
+      # must be longer than SHUTDOWN_TIMEOUT, so that the server finishes shutting down before it's killed
+      kill_timeout = "30s"
+
       config {
         command = "./local/garbage-speak-${var.version}"
       }
@@ -127,6 +135,7 @@ EOF
 POSTGRES_URL=postgresql://{{ env "NOMAD_JOB_NAME" | replaceAll "-" "_" }}_app:{{ with nomadVar "nomad/jobs/garbage_speak" }}{{ .db_password }}{{ end }}@{{ .postgres_host }}:{{ .postgres_port }}/{{ env "NOMAD_JOB_NAME" }}?sslmode=require
 {{- end }}
 {{ with nomadVar "nomad/jobs/garbage_speak" }}SMTP_PASSWORD={{ .SMTP_PASSWORD }}{{ end }}
+{{ with nomadVar "nomad/jobs/garbage_speak" }}SECRET_KEY={{ .SECRET_KEY }}{{ end }}
 EOF
         destination = "local/env"
         env         = true
@@ -150,6 +159,37 @@ EOF
         name         = "garbage-speak"
         provider     = "nomad"
         address_mode = "host"
+
+        # the process is restarted only when it stops responding; restarting doesn't fix Postgres being unavailable
+        check {
+          name     = "alive"
+          type     = "http"
+          path     = "/healthz"
+          interval = "10s"
+          timeout  = "2s"
+
+          check_restart {
+            limit = 3
+            grace = "30s"
+          }
+        }
+
+        # the service is unhealthy until Postgres is reachable, migrations have run, and background jobs have started
+        check {
+          name     = "ready"
+          type     = "http"
+          path     = "/readyz"
+          interval = "10s"
+          timeout  = "3s"
+        }
+      }
+
+      service {
+        port         = "metrics"
+        name         = "garbage-speak-metrics"
+        provider     = "nomad"
+        address_mode = "host"
+        tags         = ["metrics"]
       }
     }
   }
@@ -0,0 +1,295 @@
+package link_preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+var (
+	ErrInvalidURL        = errors.New("only http and https URLs are allowed")
+	ErrForbiddenAddress  = errors.New("link previews may not be fetched from private addresses")
+	ErrUnexpectedContent = errors.New("link does not point to an HTML document")
+)
+
+const (
+	// maxBodySize is the most of a linked document that is read while looking for its metadata
+	maxBodySize  = 1 << 20
+	maxRedirects = 5
+)
+
+// trackingParams are query parameters that identify where a link was shared rather than what it links to
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"ref_src": true,
+	"ref_url": true,
+	"si":      true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+// Preview is the OpenGraph metadata of a linked document
+type Preview struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+// NormalizeURL validates that rawURL is an absolute http or https URL and normalizes it, so that links that differ only
+// by case, default ports, fragments, tracking parameters, or query parameter order normalize to the same URL
+func NormalizeURL(rawURL string) (normalized string, err error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		err = ErrInvalidURL
+		return
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.User != nil {
+		err = ErrInvalidURL
+		return
+	}
+
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		// IPv6 literals must remain bracketed
+		host = "[" + host + "]"
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	query := u.Query()
+	for param := range query {
+		if strings.HasPrefix(strings.ToLower(param), "utm_") || trackingParams[strings.ToLower(param)] {
+			query.Del(param)
+		}
+	}
+	// Encode sorts parameters by key
+	u.RawQuery = query.Encode()
+
+	normalized = u.String()
+	return
+}
+
+// Fetcher fetches link previews
+type Fetcher struct {
+	// Timeout is the maximum amount of time spent fetching a single preview
+	Timeout time.Duration
+
+	// allowAddress permits connecting to the addresses, host and port, for which it returns true, even if they're not
+	// public. It lets tests stand in a local server for a public one.
+	allowAddress func(address string) bool
+}
+
+// NewFetcher returns a Fetcher that refuses to connect to non-public addresses
+func NewFetcher() *Fetcher {
+	return &Fetcher{Timeout: 10 * time.Second}
+}
+
+// client returns an HTTP client whose connections are checked against the fetcher's address restrictions. Addresses are
+// checked after DNS resolution, so neither redirects nor DNS rebinding can be used to reach private addresses
+func (f *Fetcher) client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: f.Timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			if f.allowAddress != nil && f.allowAddress(address) {
+				return nil
+			}
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublic(ip) {
+				return ErrForbiddenAddress
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: f.Timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   f.Timeout,
+			ResponseHeaderTimeout: f.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidURL
+			}
+
+			return nil
+		},
+	}
+}
+
+// Fetch fetches the document at rawURL and extracts its OpenGraph title, description, and image. When a document has no
+// OpenGraph metadata, its <title> and description <meta> tag are used instead
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (preview Preview, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		err = ErrInvalidURL
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "garbagespeak-link-preview/1.0")
+
+	res, err := f.client().Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("unexpected status fetching link preview: %d", res.StatusCode)
+		return
+	}
+
+	if !strings.Contains(res.Header.Get("Content-Type"), "html") {
+		err = ErrUnexpectedContent
+		return
+	}
+
+	doc, err := html.Parse(io.LimitReader(res.Body, maxBodySize))
+	if err != nil {
+		return
+	}
+
+	meta := map[string]string{}
+	var title string
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				if title == "" && n.FirstChild != nil {
+					title = n.FirstChild.Data
+				}
+			case "meta":
+				var key, content string
+				for _, attr := range n.Attr {
+					switch attr.Key {
+					case "property", "name":
+						key = strings.ToLower(attr.Val)
+					case "content":
+						content = attr.Val
+					}
+				}
+				if _, ok := meta[key]; key != "" && !ok {
+					meta[key] = content
+				}
+			case "body":
+				// metadata lives in <head>, there's no need to walk the document body
+				return
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	preview.Title = strings.TrimSpace(firstNonEmpty(meta["og:title"], title))
+	preview.Description = strings.TrimSpace(firstNonEmpty(meta["og:description"], meta["description"]))
+
+	if image := strings.TrimSpace(meta["og:image"]); image != "" {
+		// images may be relative to the document; only absolute http(s) image URLs are kept
+		if imageURL, perr := res.Request.URL.Parse(image); perr == nil &&
+			(imageURL.Scheme == "http" || imageURL.Scheme == "https") {
+			preview.Image = imageURL.String()
+		}
+	}
+
+	return
+}
+
+// isPublic reports whether ip is a publicly routable address
+func isPublic(ip net.IP) bool {
+	return !(ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		isReserved(ip))
+}
+
+// reservedNetworks are special-purpose ranges that net.IP's methods don't cover, but that never lead to a public host
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "this" network (RFC 791)
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT (RFC 6598)
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments (RFC 6890)
+	mustParseCIDR("198.18.0.0/15"), // benchmarking (RFC 2544)
+	mustParseCIDR("64:ff9b::/96"),  // NAT64, which translates to IPv4 addresses that may be private (RFC 6052)
+}
+
+func isReserved(ip net.IP) bool {
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+
+	return network
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if strings.TrimSpace(v) != "" {
+			return v
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,229 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// redacted replaces the values of sensitive attributes
+	redacted = "[REDACTED]"
+
+	// payloadKey is the job payload key under which the context of the code that queued a job is kept
+	payloadKey = "log_context"
+)
+
+// sensitiveKeys are the attribute keys whose values are never logged
+var sensitiveKeys = map[string]bool{
+	"password":         true,
+	"password_hash":    true,
+	"email":            true,
+	"recipient":        true,
+	"secret_key":       true,
+	"smtp_password":    true,
+	"verification_url": true,
+}
+
+// contextKey is the type of the key under which log attributes are kept in contexts
+type contextKey struct{}
+
+// requestAttrsKey is the type of the key under which the attributes of a request's "request served" message are kept
+type requestAttrsKey struct{}
+
+// requestAttrs are the attributes added to a request's "request served" message. Middleware that runs after the logging
+// middleware, such as session middleware, passes new contexts down the chain, so the attributes it adds are shared
+// through a mutable holder in the context rather than the context itself.
+type requestAttrs struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// New returns a logger that writes JSON to w, logging messages at level or above. Messages logged with a context
+// include the context's request ID, route, trace, and any attributes added to it with With. Sensitive attributes, such as
+// passwords and email addresses, are redacted.
+func New(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(&contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})})
+}
+
+// redact replaces the values of sensitive attributes
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if sensitiveKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, redacted)
+	}
+
+	return a
+}
+
+// contextHandler adds the attributes kept in contexts to every record logged with a context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h *contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := middleware.GetReqID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		r.AddAttrs(slog.String("route", rctx.RoutePattern()))
+	}
+
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h *contextHandler) WithGroup(name string) slog.Handler {
+	return &contextHandler{h.Handler.WithGroup(name)}
+}
+
+// With returns a copy of ctx whose log messages include the given attributes, e.g. With(ctx, "user_id", userID)
+func With(ctx context.Context, args ...any) context.Context {
+	existing, _ := ctx.Value(contextKey{}).([]slog.Attr)
+	attrs := append(append([]slog.Attr{}, existing...), toAttrs(args)...)
+
+	return context.WithValue(ctx, contextKey{}, attrs)
+}
+
+// AddToRequest adds attributes to the "request served" message that Middleware logs for the request that ctx belongs
+// to, e.g. AddToRequest(ctx, "user_id", userID). It has no effect outside of requests served by Middleware.
+func AddToRequest(ctx context.Context, args ...any) {
+	ra, ok := ctx.Value(requestAttrsKey{}).(*requestAttrs)
+	if !ok {
+		return
+	}
+
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	ra.attrs = append(ra.attrs, toAttrs(args)...)
+}
+
+// toAttrs converts alternating keys and values, and attributes, to attributes, as slog.Logger.Log does
+func toAttrs(args []any) (attrs []slog.Attr) {
+	r := slog.NewRecord(time.Time{}, 0, "", 0)
+	r.Add(args...)
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	return
+}
+
+// Middleware logs every request once it's been served, with its status, size, duration, and any attributes added with
+// AddToRequest. Server errors are logged as errors, and everything else as info.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ra := &requestAttrs{}
+		r = r.WithContext(context.WithValue(r.Context(), requestAttrsKey{}, ra))
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Int64("duration_ms", time.Since(start).Milliseconds()),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+
+		ra.mu.Lock()
+		attrs = append(attrs, ra.attrs...)
+		ra.mu.Unlock()
+
+		slog.LogAttrs(r.Context(), level, "request served", attrs...)
+	})
+}
+
+// Payload returns a copy of a job's payload that carries ctx's request ID, route, and attributes, so that the job's
+// log messages can be traced back to the request that queued it. Payloads are fingerprinted to prevent duplicate jobs,
+// so jobs must be fingerprinted before their payload is replaced.
+func Payload(ctx context.Context, payload map[string]any) map[string]any {
+	logContext := map[string]any{}
+	if id := middleware.GetReqID(ctx); id != "" {
+		logContext["request_id"] = id
+	}
+
+	if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+		logContext["route"] = rctx.RoutePattern()
+	}
+
+	if attrs, ok := ctx.Value(contextKey{}).([]slog.Attr); ok {
+		for _, a := range attrs {
+			// jobs queued by other jobs are identified by their own queue and ID
+			if a.Key == "queue" || a.Key == "job_id" {
+				continue
+			}
+
+			logContext[a.Key] = a.Value.Any()
+		}
+	}
+
+	withContext := make(map[string]any, len(payload)+1)
+	for k, v := range payload {
+		withContext[k] = v
+	}
+	if len(logContext) > 0 {
+		withContext[payloadKey] = logContext
+	}
+
+	return withContext
+}
+
+// Job wraps a neoq job handler, so that the job's log messages include its queue and ID, and the context of the code
+// that queued it. Jobs that fail are logged as errors.
+func Job(queue string, handle func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) (err error) {
+		ctx = With(ctx, "queue", queue)
+		if j, jerr := jobs.FromContext(ctx); jerr == nil {
+			ctx = With(ctx, "job_id", j.ID)
+			if logContext, ok := j.Payload[payloadKey].(map[string]any); ok {
+				for k, v := range logContext {
+					ctx = With(ctx, k, v)
+				}
+			}
+		}
+
+		err = handle(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "job failed", "error", err)
+		}
+
+		return
+	}
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// logged returns the messages logged to buf, by message
+func logged(t *testing.T, buf *bytes.Buffer) map[string]map[string]any {
+	t.Helper()
+
+	messages := map[string]map[string]any{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("unable to parse log line %q: %v", line, err)
+		}
+
+		messages[record["msg"].(string)] = record
+	}
+
+	return messages
+}
+
+func TestMiddlewareLogsRequestAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(New(&buf, slog.LevelInfo))
+
+	// identify stands in for middleware that learns who made the request after Middleware has passed it on, such as
+	// session middleware
+	identify := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			AddToRequest(r.Context(), "user_id", "42")
+			next.ServeHTTP(w, r.WithContext(With(r.Context(), "user_id", "42")))
+		})
+	}
+
+	handler := Middleware(identify(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slog.InfoContext(r.Context(), "handled", "password", "hunter2")
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/garbage/list", nil))
+
+	messages := logged(t, &buf)
+	tests := []struct {
+		message string
+		key     string
+		want    any
+	}{
+		{message: "handled", key: "user_id", want: "42"},
+		{message: "handled", key: "password", want: redacted},
+		{message: "request served", key: "user_id", want: "42"},
+		{message: "request served", key: "status", want: float64(http.StatusTeapot)},
+		{message: "request served", key: "path", want: "/garbage/list"},
+	}
+
+	for _, tt := range tests {
+		record, ok := messages[tt.message]
+		if !ok {
+			t.Fatalf("%q wasn't logged:\n%s", tt.message, buf.String())
+		}
+
+		if got := record[tt.key]; got != tt.want {
+			t.Errorf("%q logged %s = %v, want %v", tt.message, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestAddToRequestOutsideMiddleware(t *testing.T) {
+	// requests that aren't served by Middleware have nowhere to keep attributes, which is harmless
+	AddToRequest(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "user_id", "42")
+}
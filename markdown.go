@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+)
+
+// markdownRenderer renders markdown with goldmark, and sanitizes the result
+type markdownRenderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+}
+
+// newMarkdownRenderer returns the renderer with which garbage is rendered. Changes to its configuration must be
+// accompanied by incrementing renderVersion.
+func newMarkdownRenderer() *markdownRenderer {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			gmhtml.WithHardWraps(),
+		),
+	)
+
+	// user-generated content is rendered without escaping, so everything goldmark produces is run through an allowlist
+	// of elements and attributes before it's stored
+	return &markdownRenderer{md: md, policy: sanitizePolicy}
+}
+
+// newSanitizePolicy returns the policy with which rendered garbage is sanitized: bluemonday's policy for user-generated
+// content, plus GFM task list checkboxes
+func newSanitizePolicy() *bluemonday.Policy {
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+
+	return policy
+}
+
+func (m *markdownRenderer) Render(markdown string) (html string) {
+	var buf bytes.Buffer
+	if err := m.md.Convert([]byte(markdown), &buf); err != nil {
+		panic(err)
+	}
+
+	html = m.policy.Sanitize(buf.String())
+	return
+}
@@ -0,0 +1,203 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "garbage_speak"
+
+// Metrics collects the server's Prometheus metrics
+type Metrics struct {
+	registry        *prometheus.Registry
+	requestDuration *prometheus.HistogramVec
+	jobs            *prometheus.CounterVec
+	emailFailures   *prometheus.CounterVec
+	signups         prometheus.Counter
+	posts           prometheus.Counter
+	reactions       *prometheus.CounterVec
+}
+
+// New returns Metrics whose metrics are registered with their own registry, along with Go runtime and process metrics
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "How long HTTP requests take to serve, by method, route pattern, and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		jobs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_total",
+			Help:      "Background jobs run, by queue and outcome: success, failure, or panic.",
+		}, []string{"queue", "outcome"}),
+		emailFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "email_send_failures_total",
+			Help:      "Emails that failed to send, by email, e.g. welcome.",
+		}, []string{"email"}),
+		signups: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signups_total",
+			Help:      "Users who signed up.",
+		}),
+		posts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "posts_total",
+			Help:      "Garbage published, whether immediately or on schedule.",
+		}),
+		reactions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reactions_total",
+			Help:      "Reactions to garbage, by type. Uplevels are reactions of type uplevel.",
+		}, []string{"type"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestDuration,
+		m.jobs,
+		m.emailFailures,
+		m.signups,
+		m.posts,
+		m.reactions,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware observes the duration of every request. Requests are labelled by the chi route pattern that served them,
+// e.g. /garbage/{garbage_id}, rather than their path, so that the number of series doesn't grow with the number of
+// garbage posts. Requests that no route matched are served by the static content server, and are labelled "static".
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		// the static content server is the root route's NotFound handler, so its requests match the root's wildcard
+		route := "static"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" && rctx.RoutePattern() != "/*" {
+			route = rctx.RoutePattern()
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		m.requestDuration.
+			WithLabelValues(r.Method, route, strconv.Itoa(status)).
+			Observe(time.Since(start).Seconds())
+	})
+}
+
+// Job wraps a neoq job handler, counting its runs on queue by outcome
+func (m *Metrics) Job(queue string, handle func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				m.jobs.WithLabelValues(queue, "panic").Inc()
+				panic(p)
+			}
+
+			outcome := "success"
+			if err != nil {
+				outcome = "failure"
+			}
+			m.jobs.WithLabelValues(queue, outcome).Inc()
+		}()
+
+		return handle(ctx)
+	}
+}
+
+// EmailFailed counts a failure to send email, e.g. "welcome"
+func (m *Metrics) EmailFailed(email string) {
+	m.emailFailures.WithLabelValues(email).Inc()
+}
+
+// SignedUp counts a user signing up
+func (m *Metrics) SignedUp() {
+	m.signups.Inc()
+}
+
+// Posted counts garbage being published
+func (m *Metrics) Posted() {
+	m.posts.Inc()
+}
+
+// Reacted counts a reaction to garbage, e.g. "uplevel"
+func (m *Metrics) Reacted(reactionType string) {
+	m.reactions.WithLabelValues(reactionType).Inc()
+}
+
+// CollectPoolStats collects the connection pool's statistics, such as how many connections are in use, and how long
+// acquiring connections takes
+func (m *Metrics) CollectPoolStats(pool *pgxpool.Pool) {
+	m.registry.MustRegister(&poolCollector{pool: pool})
+}
+
+var (
+	poolAcquiredConns = prometheus.NewDesc(namespace+"_db_pool_acquired_connections",
+		"Connections currently acquired from the pool.", nil, nil)
+	poolIdleConns = prometheus.NewDesc(namespace+"_db_pool_idle_connections",
+		"Idle connections in the pool.", nil, nil)
+	poolTotalConns = prometheus.NewDesc(namespace+"_db_pool_total_connections",
+		"Connections in the pool, whether acquired, idle, or being constructed.", nil, nil)
+	poolMaxConns = prometheus.NewDesc(namespace+"_db_pool_max_connections",
+		"The most connections the pool may hold.", nil, nil)
+	poolAcquires = prometheus.NewDesc(namespace+"_db_pool_acquires_total",
+		"Connections successfully acquired from the pool.", nil, nil)
+	poolEmptyAcquires = prometheus.NewDesc(namespace+"_db_pool_empty_acquires_total",
+		"Acquires that had to wait for a connection because none was idle.", nil, nil)
+	poolCanceledAcquires = prometheus.NewDesc(namespace+"_db_pool_canceled_acquires_total",
+		"Acquires that were canceled before a connection became available.", nil, nil)
+	poolAcquireDuration = prometheus.NewDesc(namespace+"_db_pool_acquire_duration_seconds_total",
+		"Time spent acquiring connections from the pool.", nil, nil)
+)
+
+// poolCollector collects a pgxpool's statistics whenever metrics are scraped
+type poolCollector struct {
+	pool *pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- poolAcquiredConns
+	ch <- poolIdleConns
+	ch <- poolTotalConns
+	ch <- poolMaxConns
+	ch <- poolAcquires
+	ch <- poolEmptyAcquires
+	ch <- poolCanceledAcquires
+	ch <- poolAcquireDuration
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(poolAcquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(poolIdleConns, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(poolTotalConns, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(poolMaxConns, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(poolAcquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolEmptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolCanceledAcquires, prometheus.CounterValue, float64(stat.CanceledAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(poolAcquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1 @@
+ALTER TABLE garbages DROP COLUMN render_version;
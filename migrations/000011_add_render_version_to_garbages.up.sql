@@ -0,0 +1 @@
+ALTER TABLE garbages ADD COLUMN render_version INTEGER NOT NULL DEFAULT 0;
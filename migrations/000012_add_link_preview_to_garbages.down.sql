@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS garbages_url_idx;
+ALTER TABLE garbages DROP COLUMN link_preview;
@@ -0,0 +1,4 @@
+ALTER TABLE garbages ADD COLUMN link_preview jsonb;
+
+-- urls are normalized before they're stored, so this index may be used to find garbage seen at the same url
+CREATE INDEX IF NOT EXISTS garbages_url_idx ON public.garbages USING btree (url);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS garbage_merges;
+ALTER TABLE users DROP COLUMN is_moderator;
+DROP INDEX IF EXISTS garbages_normalized_content_trgm_idx;
+ALTER TABLE garbages DROP COLUMN normalized_content;
+DROP FUNCTION IF EXISTS normalize_garbage(text);
@@ -0,0 +1,25 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- normalize_garbage lowercases garbage and strips its punctuation and redundant whitespace, so that trivially different
+-- copies of the same garbage are compared as equals
+CREATE OR REPLACE FUNCTION normalize_garbage(content text) RETURNS text
+  LANGUAGE sql IMMUTABLE PARALLEL SAFE
+  AS $$ SELECT trim(regexp_replace(regexp_replace(lower(content), '[^[:alnum:][:space:]]+', '', 'g'), '[[:space:]]+', ' ', 'g')) $$;
+
+ALTER TABLE garbages ADD COLUMN normalized_content text GENERATED ALWAYS AS (normalize_garbage(content)) STORED;
+
+CREATE INDEX IF NOT EXISTS garbages_normalized_content_trgm_idx ON public.garbages USING gin (normalized_content gin_trgm_ops);
+
+ALTER TABLE users ADD COLUMN is_moderator boolean NOT NULL DEFAULT false;
+
+-- garbage_merges records duplicate garbage that moderators merged into a surviving post, so that permalinks to
+-- duplicates continue to work
+CREATE TABLE IF NOT EXISTS garbage_merges(
+  garbage_id uuid PRIMARY KEY,
+  merged_into uuid NOT NULL,
+  merged_by uuid,
+  created_at timestamp with time zone DEFAULT now()
+);
+
+ALTER TABLE ONLY public.garbage_merges ADD CONSTRAINT merged_into_fkey FOREIGN KEY (merged_into) REFERENCES public.garbages(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.garbage_merges ADD CONSTRAINT merged_by_fkey FOREIGN KEY (merged_by) REFERENCES public.users(id) ON DELETE SET NULL;
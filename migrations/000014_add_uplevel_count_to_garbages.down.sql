@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS uplevels_uplevel_count_trigger ON uplevels;
+DROP FUNCTION IF EXISTS update_garbage_uplevel_count();
+ALTER TABLE garbages DROP COLUMN uplevel_count;
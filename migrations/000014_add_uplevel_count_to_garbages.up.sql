@@ -0,0 +1,24 @@
+ALTER TABLE garbages ADD COLUMN uplevel_count integer NOT NULL DEFAULT 0;
+
+-- uplevel_count is kept consistent with the uplevels table by a trigger, so that listing garbage doesn't require
+-- counting uplevels
+CREATE OR REPLACE FUNCTION update_garbage_uplevel_count() RETURNS trigger
+  LANGUAGE plpgsql
+  AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    UPDATE garbages SET uplevel_count = uplevel_count + 1 WHERE id = NEW.garbage_id;
+  ELSIF TG_OP = 'DELETE' THEN
+    UPDATE garbages SET uplevel_count = uplevel_count - 1 WHERE id = OLD.garbage_id;
+  END IF;
+
+  RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER uplevels_uplevel_count_trigger AFTER INSERT OR DELETE ON uplevels
+  FOR EACH ROW EXECUTE FUNCTION update_garbage_uplevel_count();
+
+UPDATE garbages SET uplevel_count = counts.n
+FROM (SELECT garbage_id, COUNT(*) AS n FROM uplevels GROUP BY garbage_id) counts
+WHERE garbages.id = counts.garbage_id;
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS garbages_notify_garbage_events_trigger ON garbages;
+DROP FUNCTION IF EXISTS notify_garbage_events();
@@ -0,0 +1,21 @@
+-- notify_garbage_events notifies listeners on the 'garbage_events' channel when garbage is created, and when its
+-- uplevel count changes. Payloads contain IDs rather than content, since notification payloads are limited in size.
+CREATE OR REPLACE FUNCTION notify_garbage_events() RETURNS trigger
+  LANGUAGE plpgsql
+  AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    PERFORM pg_notify('garbage_events', json_build_object('type', 'garbage_created', 'garbage_id', NEW.id)::text);
+  ELSIF NEW.uplevel_count IS DISTINCT FROM OLD.uplevel_count THEN
+    PERFORM pg_notify('garbage_events', json_build_object(
+      'type', 'uplevel_count',
+      'garbage_id', NEW.id,
+      'uplevel_count', NEW.uplevel_count)::text);
+  END IF;
+
+  RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER garbages_notify_garbage_events_trigger AFTER INSERT OR UPDATE OF uplevel_count ON garbages
+  FOR EACH ROW EXECUTE FUNCTION notify_garbage_events();
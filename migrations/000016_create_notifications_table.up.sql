@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS notifications(
+  id uuid PRIMARY KEY default uuid_generate_v4(),
+  user_id uuid NOT NULL,
+  actor_id uuid,
+  type text NOT NULL,
+  garbage_id uuid,
+  read_at timestamp with time zone,
+  created_at timestamp with time zone DEFAULT now()
+);
+
+ALTER TABLE ONLY public.notifications ADD CONSTRAINT user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.notifications ADD CONSTRAINT actor_id_fkey FOREIGN KEY (actor_id) REFERENCES public.users(id) ON DELETE SET NULL;
+ALTER TABLE ONLY public.notifications ADD CONSTRAINT garbage_id_fkey FOREIGN KEY (garbage_id) REFERENCES public.garbages(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS notifications_user_id_created_at_idx ON public.notifications USING btree (user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS notifications_unread_idx ON public.notifications USING btree (user_id) WHERE read_at IS NULL;
+
+-- users receive notifications of every type unless they've disabled that type
+CREATE TABLE IF NOT EXISTS notification_preferences(
+  user_id uuid NOT NULL,
+  type text NOT NULL,
+  enabled boolean NOT NULL DEFAULT true,
+  PRIMARY KEY (user_id, type)
+);
+
+ALTER TABLE ONLY public.notification_preferences ADD CONSTRAINT user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN digest_opt_in boolean NOT NULL DEFAULT false;
+
+-- digest_sends records the weekly digests sent to each user, so that a retried digest job never sends a user the same
+-- digest twice
+CREATE TABLE IF NOT EXISTS digest_sends(
+  user_id uuid NOT NULL,
+  week date NOT NULL,
+  sent_at timestamp with time zone DEFAULT now(),
+  PRIMARY KEY (user_id, week)
+);
+
+ALTER TABLE ONLY public.digest_sends ADD CONSTRAINT user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
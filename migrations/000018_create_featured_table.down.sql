@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS featured;
+DROP TABLE IF EXISTS garbage_reports;
@@ -0,0 +1,23 @@
+-- garbage_reports records users reporting garbage that breaks the rules. Reported garbage is never featured.
+CREATE TABLE IF NOT EXISTS garbage_reports(
+  garbage_id uuid NOT NULL,
+  user_id uuid NOT NULL,
+  created_at timestamp with time zone DEFAULT now(),
+  PRIMARY KEY (garbage_id, user_id)
+);
+
+ALTER TABLE ONLY public.garbage_reports ADD CONSTRAINT garbage_id_fkey FOREIGN KEY (garbage_id) REFERENCES public.garbages(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.garbage_reports ADD CONSTRAINT user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+-- featured is the garbage of the day for each day. Garbage is either picked automatically, or pinned by a moderator.
+CREATE TABLE IF NOT EXISTS featured(
+  date date PRIMARY KEY,
+  garbage_id uuid NOT NULL,
+  pinned_by uuid,
+  created_at timestamp with time zone DEFAULT now()
+);
+
+ALTER TABLE ONLY public.featured ADD CONSTRAINT garbage_id_fkey FOREIGN KEY (garbage_id) REFERENCES public.garbages(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.featured ADD CONSTRAINT pinned_by_fkey FOREIGN KEY (pinned_by) REFERENCES public.users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS featured_garbage_id_idx ON public.featured USING btree (garbage_id);
@@ -0,0 +1,30 @@
+-- garbage is published when published_at is set. Unpublished garbage is either a draft, or scheduled to be published
+-- at publish_at. Unpublished garbage is visible only to its owner.
+ALTER TABLE garbages ADD COLUMN published_at timestamp with time zone;
+ALTER TABLE garbages ADD COLUMN publish_at timestamp with time zone;
+
+UPDATE garbages SET published_at = created_at;
+
+CREATE INDEX IF NOT EXISTS garbages_unpublished_owner_id_idx ON public.garbages USING btree (owner_id) WHERE published_at IS NULL;
+
+-- garbage is announced to listeners when it's published, rather than when it's created
+CREATE OR REPLACE FUNCTION notify_garbage_events() RETURNS trigger
+  LANGUAGE plpgsql
+  AS $$
+BEGIN
+  IF NEW.published_at IS NOT NULL AND (TG_OP = 'INSERT' OR OLD.published_at IS NULL) THEN
+    PERFORM pg_notify('garbage_events', json_build_object('type', 'garbage_created', 'garbage_id', NEW.id)::text);
+  ELSIF TG_OP = 'UPDATE' AND NEW.uplevel_count IS DISTINCT FROM OLD.uplevel_count THEN
+    PERFORM pg_notify('garbage_events', json_build_object(
+      'type', 'uplevel_count',
+      'garbage_id', NEW.id,
+      'uplevel_count', NEW.uplevel_count)::text);
+  END IF;
+
+  RETURN NULL;
+END;
+$$;
+
+DROP TRIGGER IF EXISTS garbages_notify_garbage_events_trigger ON garbages;
+CREATE TRIGGER garbages_notify_garbage_events_trigger AFTER INSERT OR UPDATE OF uplevel_count, published_at ON garbages
+  FOR EACH ROW EXECUTE FUNCTION notify_garbage_events();
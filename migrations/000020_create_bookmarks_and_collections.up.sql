@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS bookmarks(
+  user_id uuid NOT NULL,
+  garbage_id uuid NOT NULL,
+  created_at timestamp with time zone DEFAULT now(),
+  PRIMARY KEY (user_id, garbage_id)
+);
+
+ALTER TABLE ONLY public.bookmarks ADD CONSTRAINT user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.bookmarks ADD CONSTRAINT garbage_id_fkey FOREIGN KEY (garbage_id) REFERENCES public.garbages(id) ON DELETE CASCADE;
+
+-- collections are named groups of a user's bookmarks. Public collections are visible to anyone with their URL.
+CREATE TABLE IF NOT EXISTS collections(
+  id uuid PRIMARY KEY default uuid_generate_v4(),
+  owner_id uuid NOT NULL,
+  name text NOT NULL,
+  is_public boolean NOT NULL DEFAULT false,
+  created_at timestamp with time zone DEFAULT now(),
+  UNIQUE (owner_id, name)
+);
+
+ALTER TABLE ONLY public.collections ADD CONSTRAINT owner_id_fkey FOREIGN KEY (owner_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS collection_items(
+  collection_id uuid NOT NULL,
+  garbage_id uuid NOT NULL,
+  created_at timestamp with time zone DEFAULT now(),
+  PRIMARY KEY (collection_id, garbage_id)
+);
+
+ALTER TABLE ONLY public.collection_items ADD CONSTRAINT collection_id_fkey FOREIGN KEY (collection_id) REFERENCES public.collections(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.collection_items ADD CONSTRAINT garbage_id_fkey FOREIGN KEY (garbage_id) REFERENCES public.garbages(id) ON DELETE CASCADE;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS garbages_owner_id_n_idx;
+DROP TABLE IF EXISTS follows;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS follows(
+  follower_id uuid NOT NULL,
+  followee_id uuid NOT NULL,
+  created_at timestamp with time zone DEFAULT now(),
+  PRIMARY KEY (follower_id, followee_id),
+  CHECK (follower_id <> followee_id)
+);
+
+ALTER TABLE ONLY public.follows ADD CONSTRAINT follower_id_fkey FOREIGN KEY (follower_id) REFERENCES public.users(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.follows ADD CONSTRAINT followee_id_fkey FOREIGN KEY (followee_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS follows_followee_id_idx ON public.follows USING btree (followee_id);
+CREATE INDEX IF NOT EXISTS garbages_owner_id_n_idx ON public.garbages USING btree (owner_id, n DESC);
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS uplevels(
+  id uuid PRIMARY KEY default uuid_generate_v4(),
+  garbage_id uuid NOT NULL,
+  user_id uuid NOT NULL,
+  created_at timestamp with time zone DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uplevel_user_id_garbage_id_idx ON public.uplevels USING btree (garbage_id, user_id);
+CREATE INDEX IF NOT EXISTS uplevel_garbage_id_idx ON public.uplevels USING btree (garbage_id);
+
+-- reactions other than uplevels are lost
+INSERT INTO uplevels(id, garbage_id, user_id, created_at)
+SELECT id, garbage_id, user_id, created_at FROM reactions WHERE type = 'uplevel';
+
+DROP TRIGGER IF EXISTS reactions_reaction_counts_trigger ON reactions;
+DROP FUNCTION IF EXISTS update_garbage_reaction_counts();
+DROP TABLE IF EXISTS reactions;
+ALTER TABLE garbages DROP COLUMN reaction_counts;
+
+CREATE OR REPLACE FUNCTION update_garbage_uplevel_count() RETURNS trigger
+  LANGUAGE plpgsql
+  AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    UPDATE garbages SET uplevel_count = uplevel_count + 1 WHERE id = NEW.garbage_id;
+  ELSIF TG_OP = 'DELETE' THEN
+    UPDATE garbages SET uplevel_count = uplevel_count - 1 WHERE id = OLD.garbage_id;
+  END IF;
+
+  RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER uplevels_uplevel_count_trigger AFTER INSERT OR DELETE ON uplevels
+  FOR EACH ROW EXECUTE FUNCTION update_garbage_uplevel_count();
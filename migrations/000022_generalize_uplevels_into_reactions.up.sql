@@ -0,0 +1,59 @@
+-- reactions generalize uplevels: an uplevel is a reaction whose type is 'uplevel'. Users may react to garbage once with
+-- each type of reaction.
+CREATE TABLE IF NOT EXISTS reactions(
+  id uuid PRIMARY KEY default uuid_generate_v4(),
+  garbage_id uuid NOT NULL,
+  user_id uuid NOT NULL,
+  type text NOT NULL,
+  created_at timestamp with time zone DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reactions_garbage_id_user_id_type_idx ON public.reactions USING btree (garbage_id, user_id, type);
+
+ALTER TABLE ONLY public.reactions ADD CONSTRAINT garbage_id_fkey FOREIGN KEY (garbage_id) REFERENCES public.garbages(id) ON DELETE CASCADE;
+ALTER TABLE ONLY public.reactions ADD CONSTRAINT user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+INSERT INTO reactions(id, garbage_id, user_id, type, created_at)
+SELECT uplevels.id, uplevels.garbage_id, uplevels.user_id, 'uplevel', uplevels.created_at
+FROM uplevels
+JOIN garbages ON garbages.id = uplevels.garbage_id
+JOIN users ON users.id = uplevels.user_id;
+
+DROP TRIGGER IF EXISTS uplevels_uplevel_count_trigger ON uplevels;
+DROP FUNCTION IF EXISTS update_garbage_uplevel_count();
+DROP TABLE IF EXISTS uplevels;
+
+-- reaction_counts maps each reaction type to the number of users who reacted to garbage with it. uplevel_count remains
+-- the count of 'uplevel' reactions, since garbage is ranked by its uplevels.
+ALTER TABLE garbages ADD COLUMN reaction_counts jsonb NOT NULL DEFAULT '{}';
+
+CREATE OR REPLACE FUNCTION update_garbage_reaction_counts() RETURNS trigger
+  LANGUAGE plpgsql
+  AS $$
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    UPDATE garbages SET
+      reaction_counts = jsonb_set(reaction_counts, ARRAY[NEW.type], to_jsonb(COALESCE((reaction_counts->>NEW.type)::integer, 0) + 1)),
+      uplevel_count = uplevel_count + CASE WHEN NEW.type = 'uplevel' THEN 1 ELSE 0 END
+    WHERE id = NEW.garbage_id;
+  ELSIF TG_OP = 'DELETE' THEN
+    UPDATE garbages SET
+      reaction_counts = jsonb_set(reaction_counts, ARRAY[OLD.type], to_jsonb(COALESCE((reaction_counts->>OLD.type)::integer, 0) - 1)),
+      uplevel_count = uplevel_count - CASE WHEN OLD.type = 'uplevel' THEN 1 ELSE 0 END
+    WHERE id = OLD.garbage_id;
+  END IF;
+
+  RETURN NULL;
+END;
+$$;
+
+CREATE TRIGGER reactions_reaction_counts_trigger AFTER INSERT OR DELETE ON reactions
+  FOR EACH ROW EXECUTE FUNCTION update_garbage_reaction_counts();
+
+UPDATE garbages SET reaction_counts = counts.reaction_counts, uplevel_count = counts.uplevel_count
+FROM (
+  SELECT garbage_id, jsonb_object_agg(type, n) AS reaction_counts, COALESCE(SUM(n) FILTER (WHERE type = 'uplevel'), 0) AS uplevel_count
+  FROM (SELECT garbage_id, type, COUNT(*) AS n FROM reactions GROUP BY garbage_id, type) type_counts
+  GROUP BY garbage_id
+) counts
+WHERE garbages.id = counts.garbage_id;
@@ -0,0 +1,4 @@
+DELETE FROM digest_sends WHERE status <> 'sent';
+ALTER TABLE digest_sends ALTER COLUMN sent_at SET DEFAULT now();
+ALTER TABLE digest_sends DROP COLUMN IF EXISTS claimed_at;
+ALTER TABLE digest_sends DROP COLUMN IF EXISTS status;
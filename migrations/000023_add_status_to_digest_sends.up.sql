@@ -0,0 +1,7 @@
+-- digest sends are claimed before the email is sent, and the claim is committed on its own, so each send records its
+-- outcome. Digests sent before sends had a status were sent.
+ALTER TABLE digest_sends ADD COLUMN status text NOT NULL DEFAULT 'sent'
+  CHECK (status IN ('sending', 'sent', 'failed', 'skipped'));
+ALTER TABLE digest_sends ALTER COLUMN status SET DEFAULT 'sending';
+ALTER TABLE digest_sends ADD COLUMN claimed_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE digest_sends ALTER COLUMN sent_at DROP DEFAULT;
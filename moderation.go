@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// mergeGarbageHandler allows moderators to merge duplicate garbage into the post that survives it. The duplicate's
+// reactions are moved onto the surviving post, and permalinks to the duplicate redirect to the surviving post.
+func (s *Server) mergeGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	duplicateID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if !s.isModerator(r) {
+		s.renderError(w, r, errForbidden)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	survivorID := strings.TrimSpace(r.PostForm.Get("into"))
+	if survivorID == "" || survivorID == duplicateID {
+		s.renderError(w, r, badRequest("Choose another post to merge this one into.", nil))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(ctx)
+
+	var found int
+	err = tx.QueryRow(ctx, "SELECT COUNT(*) FROM (SELECT id FROM garbages WHERE id IN ($1, $2) FOR UPDATE) g", duplicateID, survivorID).
+		Scan(&found)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if found != 2 {
+		s.renderError(w, r, notFound("One of the posts being merged doesn't exist.", nil))
+		return
+	}
+
+	// users who reacted to both posts keep only their reactions to the survivor
+	_, err = tx.Exec(ctx,
+		`INSERT INTO reactions(garbage_id, user_id, type, created_at)
+		SELECT $1, user_id, type, created_at FROM reactions WHERE garbage_id = $2
+		ON CONFLICT (garbage_id, user_id, type) DO NOTHING`,
+		survivorID,
+		duplicateID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM reactions WHERE garbage_id = $1", duplicateID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// bookmarks and collections of the duplicate keep the survivor, rather than being deleted along with the duplicate
+	_, err = tx.Exec(ctx,
+		`INSERT INTO bookmarks(user_id, garbage_id, created_at)
+		SELECT user_id, $1, created_at FROM bookmarks WHERE garbage_id = $2
+		ON CONFLICT (user_id, garbage_id) DO NOTHING`,
+		survivorID,
+		duplicateID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO collection_items(collection_id, garbage_id, created_at)
+		SELECT collection_id, $1, created_at FROM collection_items WHERE garbage_id = $2
+		ON CONFLICT (collection_id, garbage_id) DO NOTHING`,
+		survivorID,
+		duplicateID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// days on which the duplicate was featured feature the survivor instead
+	_, err = tx.Exec(ctx, "UPDATE featured SET garbage_id = $1 WHERE garbage_id = $2", survivorID, duplicateID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// garbage previously merged into the duplicate now redirects to the survivor
+	_, err = tx.Exec(ctx, "UPDATE garbage_merges SET merged_into = $1 WHERE merged_into = $2", survivorID, duplicateID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		"INSERT INTO garbage_merges(garbage_id, merged_into, merged_by) VALUES ($1, $2, $3)",
+		duplicateID,
+		survivorID,
+		userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// the duplicate's owner is told which garbage their duplicate was merged into
+	var duplicateOwnerID string
+	err = tx.QueryRow(ctx, "SELECT owner_id FROM garbages WHERE id = $1", duplicateID).Scan(&duplicateOwnerID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = notify(ctx, tx, "moderation", duplicateOwnerID, userID, survivorID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	_, err = tx.Exec(ctx, "DELETE FROM garbages WHERE id = $1", duplicateID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	w.Header().Add("hx-location", fmt.Sprintf("%s/garbage/%s", s.config.APIURL(), survivorID))
+}
+
+// reportGarbageHandler reports garbage to the moderators
+func (s *Server) reportGarbageHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	_, err := s.store.Exec(r.Context(),
+		"INSERT INTO garbage_reports(garbage_id, user_id) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		garbageID,
+		userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	w.Write([]byte("<span>Reported</span>"))
+}
+
+// isModerator returns whether the logged in user is a moderator
+func (s *Server) isModerator(r *http.Request) (moderator bool) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		return
+	}
+
+	s.store.QueryRow(r.Context(), "SELECT is_moderator FROM users WHERE id = $1", userID).Scan(&moderator)
+
+	return
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/acaloiaro/neoq/backends/postgres"
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Notification represents 'notification' records from the database. Notifications tell users about activity related to
+// their garbage.
+type Notification struct {
+	ID            uuid.UUID
+	UserID        uuid.UUID
+	ActorID       *uuid.UUID // the user whose action caused the notification, if any
+	ActorUsername *string
+	Type          string
+	GarbageID     *uuid.UUID
+	GarbageTitle  *string
+	ReadAt        *time.Time
+	CreatedAt     time.Time
+}
+
+// NotificationType is a kind of activity that users may be notified of
+type NotificationType struct {
+	Name        string
+	Description string
+}
+
+// notificationTypes are the kinds of activity users are notified of. Users may disable notifications of each type.
+var notificationTypes = []NotificationType{
+	{Name: "uplevel", Description: "Someone upleveled my garbage"},
+	{Name: "moderation", Description: "A moderator acted on my garbage"},
+	{Name: "new_post", Description: "Someone I follow posted new garbage"},
+}
+
+// enqueueFollowerNotifications queues notifying the followers of garbage's owner that the garbage was published. Users
+// may have many followers, so followers are notified in the background.
+func (s *Server) enqueueFollowerNotifications(ctx context.Context, garbageID string) {
+	err := s.enqueue(ctx, &jobs.Job{
+		Queue:   "notify_followers",
+		Payload: map[string]any{"garbage_id": garbageID},
+	})
+	if err != nil && !errors.Is(err, postgres.ErrDuplicateJob) {
+		slog.ErrorContext(ctx, "unable to queue follower notifications", "garbage_id", garbageID, "error", err)
+	}
+}
+
+// notifyFollowersHandler notifies the followers of garbage's owner that the garbage was published
+func (s *Server) notifyFollowersHandler(ctx context.Context) (err error) {
+	var j *jobs.Job
+	j, err = jobs.FromContext(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "unable to notify followers", "error", err)
+		return
+	}
+
+	_, err = s.store.Exec(ctx,
+		`INSERT INTO notifications(user_id, actor_id, type, garbage_id)
+		SELECT follows.follower_id, garbages.owner_id, 'new_post', garbages.id
+		FROM garbages
+		JOIN follows ON follows.followee_id = garbages.owner_id
+		WHERE garbages.id = $1 AND garbages.published_at IS NOT NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM notification_preferences
+			WHERE user_id = follows.follower_id AND type = 'new_post' AND NOT enabled
+		)`,
+		j.Payload["garbage_id"])
+
+	return
+}
+
+// notify notifies a user of activity of the given type, unless they've disabled notifications of that type or they're
+// the actor responsible for the activity. actorID and garbageID may be empty.
+func notify(ctx context.Context, tx pgx.Tx, notificationType, userID, actorID, garbageID string) (err error) {
+	_, err = tx.Exec(ctx,
+		`INSERT INTO notifications(user_id, actor_id, type, garbage_id)
+		SELECT $1::uuid, NULLIF($2, '')::uuid, $3, NULLIF($4, '')::uuid
+		WHERE $1::uuid IS DISTINCT FROM NULLIF($2, '')::uuid
+		AND NOT EXISTS (SELECT 1 FROM notification_preferences WHERE user_id = $1::uuid AND type = $3 AND NOT enabled)`,
+		userID,
+		actorID,
+		notificationType,
+		garbageID)
+
+	return
+}
+
+// notifyGarbageOwner notifies the owner of garbage of activity of the given type related to their garbage
+func notifyGarbageOwner(ctx context.Context, tx pgx.Tx, notificationType, garbageID, actorID string) (err error) {
+	var ownerID string
+	err = tx.QueryRow(ctx, "SELECT owner_id FROM garbages WHERE id = $1", garbageID).Scan(&ownerID)
+	if err != nil {
+		return
+	}
+
+	return notify(ctx, tx, notificationType, ownerID, actorID, garbageID)
+}
+
+// listNotificationsHandler lists the current user's latest notifications, along with their notification preferences
+func (s *Server) listNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	notifications := []*Notification{}
+	err := pgxscan.Select(ctx, s.store, &notifications,
+		`SELECT notifications.id, user_id, actor_id, users.username AS actor_username, type, garbage_id,
+		garbages.title AS garbage_title, read_at, notifications.created_at
+		FROM notifications
+		LEFT JOIN users ON notifications.actor_id = users.id
+		LEFT JOIN garbages ON notifications.garbage_id = garbages.id
+		WHERE user_id = $1
+		ORDER BY notifications.created_at DESC
+		LIMIT $2`,
+		userID,
+		pageSize)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	disabledTypes := []string{}
+	err = pgxscan.Select(ctx, s.store, &disabledTypes,
+		"SELECT type FROM notification_preferences WHERE user_id = $1 AND NOT enabled",
+		userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	enabledTypes := map[string]bool{}
+	for _, t := range notificationTypes {
+		enabledTypes[t.Name] = true
+	}
+	for _, t := range disabledTypes {
+		enabledTypes[t] = false
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "notifications", "list.html", map[string]any{
+		"Notifications":     notifications,
+		"NotificationTypes": notificationTypes,
+		"EnabledTypes":      enabledTypes,
+		"ApiBaseUrl":        s.config.APIURL(),
+		"UnreadCount":       s.unreadNotificationCount(ctx, userID),
+		// partial requests update the unread count shown in the nav, which may have changed
+		"OOB": isPartialRequest(r),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// readNotificationHandler marks one of the current user's notifications as read
+func (s *Server) readNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	notificationID := chi.URLParam(r, "notification_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	notification := Notification{}
+	err := pgxscan.Get(ctx, s.store, &notification,
+		`WITH notification AS (
+			UPDATE notifications SET read_at = COALESCE(read_at, now()) WHERE id = $1 AND user_id = $2 RETURNING *
+		)
+		SELECT notification.id, user_id, actor_id, users.username AS actor_username, type, garbage_id,
+		garbages.title AS garbage_title, read_at, notification.created_at
+		FROM notification
+		LEFT JOIN users ON notification.actor_id = users.id
+		LEFT JOIN garbages ON notification.garbage_id = garbages.id`,
+		notificationID,
+		userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.writeTemplate(r.Context(), w, "notifications", "notification", map[string]any{
+		"Notification": notification,
+		"ApiBaseUrl":   s.config.APIURL(),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderUnreadNotificationCount(ctx, w, r, userID)
+}
+
+// readAllNotificationsHandler marks all of the current user's notifications as read
+func (s *Server) readAllNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	_, err := s.store.Exec(r.Context(), "UPDATE notifications SET read_at = now() WHERE user_id = $1 AND read_at IS NULL", userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.listNotificationsHandler(w, r)
+}
+
+// updateNotificationPreferencesHandler saves which types of notifications the current user receives
+func (s *Server) updateNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	enabled := map[string]bool{}
+	for _, t := range r.PostForm["enabled"] {
+		enabled[t] = true
+	}
+
+	ctx := r.Context()
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(ctx)
+
+	for _, t := range notificationTypes {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO notification_preferences(user_id, type, enabled) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, type) DO UPDATE SET enabled = EXCLUDED.enabled`,
+			userID,
+			t.Name,
+			enabled[t.Name])
+		if err != nil {
+			s.renderError(w, r, err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.listNotificationsHandler(w, r)
+}
+
+// unreadNotificationCount returns the number of unread notifications the given user has
+func (s *Server) unreadNotificationCount(ctx context.Context, userID string) (count int) {
+	s.store.QueryRow(ctx, "SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND read_at IS NULL", userID).Scan(&count)
+	return
+}
+
+// renderUnreadNotificationCount renders the user's unread notification count as an out-of-band swap, updating the
+// count shown in the nav
+func (s *Server) renderUnreadNotificationCount(ctx context.Context, w http.ResponseWriter, r *http.Request, userID string) {
+	err := s.writeTemplate(ctx, w, "notifications", "unread_count", map[string]any{
+		"UnreadCount": s.unreadNotificationCount(ctx, userID),
+		"OOB":         true,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// ReactionType is a kind of reaction users may have to garbage
+type ReactionType struct {
+	Name  string // the reaction's name, as stored in reactions.type
+	Label string // the reaction's name, as shown to users
+}
+
+// Reaction is the number of users who reacted to garbage with a type of reaction, and whether the current user did
+type Reaction struct {
+	ReactionType
+	Count   int
+	Reacted bool
+}
+
+// ReactionSummary returns the garbage's reactions of each of types, in order. Uplevels are not included, since garbage
+// displays them separately.
+func (g Garbage) ReactionSummary(types []ReactionType) (reactions []Reaction) {
+	for _, t := range types {
+		reactions = append(reactions, Reaction{
+			ReactionType: t,
+			Count:        g.ReactionCounts[t.Name],
+			Reacted:      slices.Contains(g.Reactions, t.Name),
+		})
+	}
+
+	return
+}
+
+// defaultReactionTypes are the reactions users may have to garbage in addition to uplevels, unless others are configured
+// with REACTION_TYPES, see parseReactionTypes
+var defaultReactionTypes = []ReactionType{
+	{Name: "synergy", Label: "Synergy"},
+	{Name: "circle_back", Label: "Circle back"},
+	{Name: "hard_pass", Label: "Hard pass"},
+}
+
+// reactionNamePattern matches valid reaction names
+var reactionNamePattern = regexp.MustCompile(`^[a-z][a-z_]*$`)
+
+// parseReactionTypes parses reaction types configured as a comma-separated list of names and labels, e.g.
+// "synergy:Synergy,circle_back:Circle back". Names consist of lowercase letters and underscores. Uplevels are always
+// available, and may not be configured.
+func parseReactionTypes(config string) (types []ReactionType) {
+	for _, entry := range strings.Split(config, ",") {
+		name, label, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		name = strings.TrimSpace(name)
+		label = strings.TrimSpace(label)
+		if label == "" {
+			label = name
+		}
+
+		if !reactionNamePattern.MatchString(name) || name == "uplevel" {
+			slog.Warn("ignoring invalid reaction type", "reaction_type", entry)
+			continue
+		}
+
+		types = append(types, ReactionType{Name: name, Label: label})
+	}
+
+	return
+}
+
+func (s *Server) getUplevelHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+
+	var uplevel int
+	err := s.store.QueryRow(r.Context(), "SELECT uplevel_count FROM garbages WHERE id = $1", garbageID).Scan(&uplevel)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	w.Write([]byte(strconv.Itoa(uplevel)))
+}
+
+// addUplevelHandler uplevels garbage on behalf of the current user. Upleveling garbage more than once has no effect.
+func (s *Server) addUplevelHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(ctx)
+
+	added, err := react(ctx, tx, garbageID, userID, "uplevel")
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if added {
+		err = notifyGarbageOwner(ctx, tx, "uplevel", garbageID, userID)
+		if err != nil {
+			s.renderError(w, r, err)
+			return
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if added {
+		s.metrics.Reacted("uplevel")
+	}
+
+	s.renderUplevelButton(ctx, w, r, garbageID, userID)
+}
+
+// removeUplevelHandler takes back the current user's uplevel of garbage. Removing an uplevel that does not exist has no
+// effect.
+func (s *Server) removeUplevelHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	err := pgx.BeginFunc(ctx, s.store, func(tx pgx.Tx) (err error) {
+		_, err = tx.Exec(ctx,
+			"DELETE FROM reactions WHERE garbage_id = $1 AND user_id = $2 AND type = 'uplevel'",
+			garbageID,
+			userID)
+		if err != nil {
+			return
+		}
+
+		// owners who haven't seen the uplevel yet don't need to hear about it
+		_, err = tx.Exec(ctx,
+			"DELETE FROM notifications WHERE type = 'uplevel' AND garbage_id = $1 AND actor_id = $2 AND read_at IS NULL",
+			garbageID,
+			userID)
+		return
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderUplevelButton(ctx, w, r, garbageID, userID)
+}
+
+// renderUplevelButton renders garbage's uplevel button as seen by the given user. Buttons on pages that receive live
+// events keep updating their counts live.
+func (s *Server) renderUplevelButton(ctx context.Context, w http.ResponseWriter, r *http.Request, garbageID, userID string) {
+	garbage := Garbage{}
+	err := pgxscan.Get(ctx, s.store, &garbage, "SELECT id, uplevel_count, "+upleveledColumn+" FROM garbages WHERE id = $2", userID, garbageID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.writeTemplate(ctx, w, "garbage", "uplevel_button.tmpl", map[string]any{
+		"Garbage":    garbage,
+		"ApiBaseUrl": s.config.APIURL(),
+		"UserID":     userID,
+		"Live":       r.URL.Query().Get("live") == "true",
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// react reacts to published garbage on behalf of a user. added is false when the user already reacted to the garbage with
+// the given type of reaction.
+func react(ctx context.Context, tx pgx.Tx, garbageID, userID, reactionType string) (added bool, err error) {
+	// garbages' reaction counts are maintained by a trigger on reactions, and duplicate reactions insert no rows, so
+	// concurrent reactions never miscount
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO reactions(garbage_id, user_id, type)
+		SELECT id, $2, $3 FROM garbages WHERE id = $1 AND published_at IS NOT NULL
+		ON CONFLICT (garbage_id, user_id, type) DO NOTHING`,
+		garbageID,
+		userID,
+		reactionType)
+	if err != nil {
+		return
+	}
+
+	added = tag.RowsAffected() > 0
+	return
+}
+
+// reactionType returns the configured reaction type with the given name
+func (s *Server) reactionType(name string) (t ReactionType, ok bool) {
+	i := slices.IndexFunc(s.reactionTypes, func(t ReactionType) bool { return t.Name == name })
+	if i < 0 {
+		return
+	}
+
+	return s.reactionTypes[i], true
+}
+
+// addReactionHandler reacts to garbage on behalf of the current user. Reacting to garbage more than once with the same
+// type of reaction has no effect.
+func (s *Server) addReactionHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	t, ok := s.reactionType(chi.URLParam(r, "reaction"))
+	if !ok {
+		s.renderError(w, r, notFound("That reaction doesn't exist.", nil))
+		return
+	}
+
+	ctx := r.Context()
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(ctx)
+
+	added, err := react(ctx, tx, garbageID, userID, t.Name)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if added {
+		s.metrics.Reacted(t.Name)
+	}
+
+	s.renderReactionButton(ctx, w, r, garbageID, userID, t)
+}
+
+// removeReactionHandler takes back the current user's reaction to garbage
+func (s *Server) removeReactionHandler(w http.ResponseWriter, r *http.Request) {
+	garbageID := chi.URLParam(r, "garbage_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	t, ok := s.reactionType(chi.URLParam(r, "reaction"))
+	if !ok {
+		s.renderError(w, r, notFound("That reaction doesn't exist.", nil))
+		return
+	}
+
+	ctx := r.Context()
+	_, err := s.store.Exec(ctx,
+		"DELETE FROM reactions WHERE garbage_id = $1 AND user_id = $2 AND type = $3",
+		garbageID,
+		userID,
+		t.Name)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderReactionButton(ctx, w, r, garbageID, userID, t)
+}
+
+// renderReactionButton renders garbage's button for a type of reaction as seen by the given user
+func (s *Server) renderReactionButton(ctx context.Context, w http.ResponseWriter, r *http.Request, garbageID, userID string, t ReactionType) {
+	reaction := Reaction{ReactionType: t}
+	err := s.store.QueryRow(ctx,
+		`SELECT COALESCE((reaction_counts->>$3)::integer, 0), EXISTS (
+			SELECT 1 FROM reactions WHERE garbage_id = garbages.id AND user_id = $1 AND type = $3
+		)
+		FROM garbages WHERE id = $2`,
+		userID,
+		garbageID,
+		t.Name).Scan(&reaction.Count, &reaction.Reacted)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.writeTemplate(ctx, w, "garbage", "reaction_button.tmpl", map[string]any{
+		"Reaction":   reaction,
+		"GarbageID":  garbageID,
+		"UserID":     userID,
+		"ApiBaseUrl": s.config.APIURL(),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
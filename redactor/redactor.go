@@ -0,0 +1,165 @@
+package redactor
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	ColleaguePlaceholder = "[COLLEAGUE]"
+	CompanyPlaceholder   = "[COMPANY]"
+	PhonePlaceholder     = "[PHONE NUMBER]"
+)
+
+var (
+	emailPattern = regexp.MustCompile(`(?i)[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}`)
+	// mentions must not be preceded by a word character, otherwise the local part of email addresses would match
+	mentionPattern   = regexp.MustCompile(`(?:^|[^\w@.])(@[A-Za-z0-9_][A-Za-z0-9_.\-]*[A-Za-z0-9_])`)
+	slackLinkPattern = regexp.MustCompile(`<@[UW][A-Z0-9]{6,}(?:\|[^>]*)?>`)
+	slackIDPattern   = regexp.MustCompile(`\b[UW][A-Z0-9]{8,10}\b`)
+	phonePattern     = regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?(?:\(\d{3}\)|\b\d{3})[\s.\-]?\d{3}[\s.\-]?\d{4}\b`)
+)
+
+// Segment is a contiguous piece of redacted content. Segments that were redacted contain both the original text and
+// the placeholder that replaced it
+type Segment struct {
+	Text        string // the original text
+	Placeholder string // the text's replacement, empty if the text was not redacted
+}
+
+// Redacted returns whether the segment was redacted
+func (s Segment) Redacted() bool {
+	return s.Placeholder != ""
+}
+
+// Result is the result of redacting content
+type Result struct {
+	Content  string    // the redacted content
+	Segments []Segment // the original content split into redacted and unredacted segments, useful for showing diffs
+}
+
+// Redacted returns whether any of the content was redacted
+func (r Result) Redacted() bool {
+	for _, s := range r.Segments {
+		if s.Redacted() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Redactor replaces personally identifying information, as well as denylisted colleague and company names, with
+// placeholders
+type Redactor struct {
+	colleagues []*regexp.Regexp
+	companies  []*regexp.Regexp
+}
+
+// match is a span of content to be replaced with a placeholder
+type match struct {
+	start, end  int
+	placeholder string
+}
+
+// New returns a Redactor that redacts the given colleague and company names, in addition to email addresses, phone
+// numbers, @mentions and Slack user IDs
+func New(colleagues, companies []string) *Redactor {
+	return &Redactor{
+		colleagues: namePatterns(colleagues),
+		companies:  namePatterns(companies),
+	}
+}
+
+// namePatterns compiles case-insensitive, whole word patterns for each name
+func namePatterns(names []string) (patterns []*regexp.Regexp) {
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		patterns = append(patterns, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(name)+`\b`))
+	}
+
+	return
+}
+
+// Redact redacts content
+func (r *Redactor) Redact(content string) (result Result) {
+	matches := []match{}
+	add := func(loc []int, placeholder string) {
+		matches = append(matches, match{start: loc[0], end: loc[1], placeholder: placeholder})
+	}
+
+	for _, loc := range emailPattern.FindAllStringIndex(content, -1) {
+		add(loc, ColleaguePlaceholder)
+	}
+
+	for _, loc := range slackLinkPattern.FindAllStringIndex(content, -1) {
+		add(loc, ColleaguePlaceholder)
+	}
+
+	for _, loc := range slackIDPattern.FindAllStringIndex(content, -1) {
+		// words in all caps are not Slack IDs; IDs always contain digits
+		if strings.IndexFunc(content[loc[0]:loc[1]], unicode.IsDigit) >= 0 {
+			add(loc, ColleaguePlaceholder)
+		}
+	}
+
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		add(loc[2:4], ColleaguePlaceholder)
+	}
+
+	for _, loc := range phonePattern.FindAllStringIndex(content, -1) {
+		add(loc, PhonePlaceholder)
+	}
+
+	for _, p := range r.colleagues {
+		for _, loc := range p.FindAllStringIndex(content, -1) {
+			add(loc, ColleaguePlaceholder)
+		}
+	}
+
+	for _, p := range r.companies {
+		for _, loc := range p.FindAllStringIndex(content, -1) {
+			add(loc, CompanyPlaceholder)
+		}
+	}
+
+	// when matches overlap, the earliest, and then longest, match wins
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].start != matches[j].start {
+			return matches[i].start < matches[j].start
+		}
+		return matches[i].end > matches[j].end
+	})
+
+	var redacted strings.Builder
+	pos := 0
+	for _, m := range matches {
+		if m.start < pos {
+			continue
+		}
+
+		if m.start > pos {
+			result.Segments = append(result.Segments, Segment{Text: content[pos:m.start]})
+			redacted.WriteString(content[pos:m.start])
+		}
+
+		result.Segments = append(result.Segments, Segment{Text: content[m.start:m.end], Placeholder: m.placeholder})
+		redacted.WriteString(m.placeholder)
+		pos = m.end
+	}
+
+	if pos < len(content) {
+		result.Segments = append(result.Segments, Segment{Text: content[pos:]})
+		redacted.WriteString(content[pos:])
+	}
+
+	result.Content = redacted.String()
+
+	return
+}
@@ -0,0 +1,123 @@
+package redactor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	r := New([]string{"Jane Doe", " Bob ", ""}, []string{"Initech"})
+
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "email", content: "ping jane.doe+work@example.co.uk today", want: "ping [COLLEAGUE] today"},
+		{name: "uppercase email", content: "JANE@EXAMPLE.COM", want: "[COLLEAGUE]"},
+		{name: "mention", content: "thanks @jane.doe!", want: "thanks [COLLEAGUE]!"},
+		{name: "mention at start", content: "@bob_1 said so", want: "[COLLEAGUE] said so"},
+		{name: "slack link", content: "cc <@U012AB3CD|jane>", want: "cc [COLLEAGUE]"},
+		{name: "slack ID", content: "assigned to U012AB3CD", want: "assigned to [COLLEAGUE]"},
+		{name: "phone with dashes", content: "call 555-123-4567", want: "call [PHONE NUMBER]"},
+		{name: "phone with parentheses", content: "call (555) 123-4567", want: "call [PHONE NUMBER]"},
+		{name: "phone with dots", content: "call 555.123.4567", want: "call [PHONE NUMBER]"},
+		{name: "phone with country code", content: "call +1 555 123 4567", want: "call [PHONE NUMBER]"},
+		{name: "colleague", content: "Jane Doe wants to circle back", want: "[COLLEAGUE] wants to circle back"},
+		{name: "colleague in lowercase", content: "ask jane doe", want: "ask [COLLEAGUE]"},
+		{name: "colleague with surrounding whitespace", content: "Bob said so", want: "[COLLEAGUE] said so"},
+		{name: "company", content: "synergy at INITECH", want: "synergy at [COMPANY]"},
+		{name: "email containing a company", content: "bob@initech.com", want: "[COLLEAGUE]"},
+		{
+			name:    "several",
+			content: "Jane Doe (jane@example.com, 555-123-4567) of Initech",
+			want:    "[COLLEAGUE] ([COLLEAGUE], [PHONE NUMBER]) of [COMPANY]",
+		},
+
+		// content that must not be redacted
+		{name: "part of a colleague's name", content: "Bobby and Janet", want: "Bobby and Janet"},
+		{name: "part of a company's name", content: "Initechnology", want: "Initechnology"},
+		{name: "at sign", content: "meet @ 5pm", want: "meet @ 5pm"},
+		{name: "all caps words", content: "WORKFLOWS UNDERWATER", want: "WORKFLOWS UNDERWATER"},
+		{name: "short numbers", content: "123-4567 and 2024", want: "123-4567 and 2024"},
+		{name: "version numbers", content: "upgrade to v1.22.3", want: "upgrade to v1.22.3"},
+		{name: "long numbers", content: "order 12345678901234", want: "order 12345678901234"},
+		{name: "empty", content: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := r.Redact(tt.content)
+			if result.Content != tt.want {
+				t.Errorf("Redact(%q).Content = %q, want %q", tt.content, result.Content, tt.want)
+			}
+
+			if redacted := tt.want != tt.content; result.Redacted() != redacted {
+				t.Errorf("Redact(%q).Redacted() = %v, want %v", tt.content, result.Redacted(), redacted)
+			}
+		})
+	}
+}
+
+func TestRedactSegments(t *testing.T) {
+	r := New([]string{"Jane Doe"}, []string{"Initech"})
+
+	tests := []struct {
+		name    string
+		content string
+		want    []Segment
+	}{
+		{
+			name:    "unredacted",
+			content: "circle back",
+			want:    []Segment{{Text: "circle back"}},
+		},
+		{
+			name:    "redacted throughout",
+			content: "Call Jane Doe at 555-123-4567 about Initech",
+			want: []Segment{
+				{Text: "Call "},
+				{Text: "Jane Doe", Placeholder: ColleaguePlaceholder},
+				{Text: " at "},
+				{Text: "555-123-4567", Placeholder: PhonePlaceholder},
+				{Text: " about "},
+				{Text: "Initech", Placeholder: CompanyPlaceholder},
+			},
+		},
+		{
+			name:    "adjacent",
+			content: "Jane Doe Initech",
+			want: []Segment{
+				{Text: "Jane Doe", Placeholder: ColleaguePlaceholder},
+				{Text: " "},
+				{Text: "Initech", Placeholder: CompanyPlaceholder},
+			},
+		},
+		{
+			name:    "overlapping",
+			content: "mail jane@initech.com",
+			want: []Segment{
+				{Text: "mail "},
+				{Text: "jane@initech.com", Placeholder: ColleaguePlaceholder},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := r.Redact(tt.content).Segments
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Redact(%q).Segments = %+v, want %+v", tt.content, got, tt.want)
+			}
+
+			// the diff is made of every piece of the original content, in order
+			var original string
+			for _, s := range got {
+				original += s.Text
+			}
+			if original != tt.content {
+				t.Errorf("Segments joined = %q, want %q", original, tt.content)
+			}
+		})
+	}
+}
@@ -3,33 +3,43 @@ package main
 import (
 	"bytes"
 	"context"
-	"crypto/tls"
 	"embed"
 	"errors"
+	"flag"
 	"fmt"
+	"html/template"
+	"io"
 	"io/fs"
-	"log"
-	"net"
+	"log/slog"
 	"net/http"
-	"net/smtp"
 	"os"
+	"os/signal"
+	"path"
 	"strconv"
 	"strings"
-	"text/template"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/acaloiaro/garbage_speak/app_config"
+	"github.com/acaloiaro/garbage_speak/events"
 	"github.com/acaloiaro/garbage_speak/html_parser"
+	"github.com/acaloiaro/garbage_speak/link_preview"
+	"github.com/acaloiaro/garbage_speak/logging"
+	"github.com/acaloiaro/garbage_speak/metrics"
+	"github.com/acaloiaro/garbage_speak/redactor"
+	"github.com/acaloiaro/garbage_speak/templates"
+	"github.com/acaloiaro/garbage_speak/tracing"
 	"github.com/acaloiaro/neoq"
 	"github.com/acaloiaro/neoq/backends/postgres"
 	"github.com/acaloiaro/neoq/handler"
 	"github.com/acaloiaro/neoq/jobs"
 	"github.com/alexedwards/scs/pgxstore"
 	"github.com/alexedwards/scs/v2"
-	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
-	"github.com/gofrs/uuid"
+	"github.com/go-chi/httprate"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
@@ -37,48 +47,8 @@ import (
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	gmhtml "github.com/yuin/goldmark/renderer/html"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
-// Garbage represents 'garbage' records from the database
-type Garbage struct {
-	ID              uuid.UUID
-	OwnerID         uuid.UUID
-	Username        string
-	Title           string
-	Content         string  // the raw, user-supplied content
-	RenderedContent *string // the content run through goldmark
-	Metadata        map[string]any
-	Url             string
-	CreatedAt       time.Time
-	N               int
-}
-
-// User represents 'user' records from the database
-type User struct {
-	ID           uuid.UUID
-	Username     string
-	PasswordHash string
-	Email        string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-}
-
-// UserEmailVerification models pending user email verifications. If a User has a UserEmailVeritifcation, the account is
-// pending verification and is not eligible to log in
-type UserEmailVerification struct {
-	ID        uuid.UUID
-	User      *User
-	UserID    uuid.UUID
-	CreatedAt time.Time
-	UpdatedAt time.Time
-}
-
 //go:embed migrations/*.sql
 var migrationsFS embed.FS
 
@@ -88,909 +58,780 @@ var publicFS embed.FS
 //go:embed all:partials
 var partialsFS embed.FS
 
+// views are the sets of partials that are rendered together. Partials in different directories share names, e.g.
+// list.html, so each directory is its own view, along with the garbage partials it embeds.
+var views = map[string][]string{
+	"collections":   {"partials/collections/*.html", "partials/garbage/list.html", "partials/garbage/*.tmpl"},
+	"errors":        {"partials/errors/*.html"},
+	"garbage":       {"partials/garbage/*.html", "partials/garbage/*.tmpl"},
+	"nav":           {"partials/nav/*.html", "partials/notifications/unread_count.html"},
+	"notifications": {"partials/notifications/*.html"},
+	"users":         {"partials/users/*.html", "partials/garbage/list.html", "partials/garbage/*.tmpl"},
+}
+
+// templateFuncs are the functions available to every partial
+var templateFuncs = template.FuncMap{
+	"argsfn": argsfn,
+}
+
 var (
-	md           goldmark.Markdown
-	db           *pgxpool.Pool
-	sessions     *scs.SessionManager
-	sessionStore *pgxstore.PostgresStore
-	NQ           neoq.Neoq
-	pageSize     = 25
-)
+	pageSize = 25
 
-// run migrations, acquire a database connection pool, and create the session store
-func init() {
-	d, err := iofs.New(migrationsFS, "migrations")
-	if err != nil {
-		log.Fatal(err)
-	}
+	// previewRateLimit is the number of markdown previews a single client may request per minute
+	previewRateLimit = 60
 
-	m, err := migrate.NewWithSourceInstance("iofs", d, os.Getenv("POSTGRES_URL"))
-	if err != nil {
-		log.Fatal(err)
-	}
+	// renderVersion is the version of the markdown rendering pipeline. Garbage whose render_version is lower than this
+	// is re-rendered by the rerender_garbage job at startup, so increment it whenever the goldmark configuration or the
+	// sanitization policy changes
+	renderVersion = 1
 
-	err = m.Up()
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "migrations: %v\n", err)
-	}
+	// sanitizePolicy is the allowlist of elements and attributes that rendered garbage may contain
+	sanitizePolicy = newSanitizePolicy()
 
-	dbconfig, err := pgxpool.ParseConfig(os.Getenv("POSTGRES_URL"))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to configure database: %v\n", err)
-		os.Exit(1)
-	}
-	dbconfig.AfterConnect = func(_ context.Context, conn *pgx.Conn) error {
-		pgxuuid.Register(conn.TypeMap())
-		return nil
-	}
+	// upleveledColumn selects whether the user whose ID is the query's first argument upleveled each garbage. Anonymous
+	// users' IDs are empty, and have upleveled nothing
+	upleveledColumn = `EXISTS (
+		SELECT 1 FROM reactions
+		WHERE reactions.garbage_id = garbages.id AND reactions.user_id = NULLIF($1, '')::uuid AND reactions.type = 'uplevel'
+	) AS upleveled`
 
-	db, err = pgxpool.NewWithConfig(context.Background(), dbconfig)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
-	}
+	// bookmarkedColumn selects whether the user whose ID is the query's first argument bookmarked each garbage
+	bookmarkedColumn = `EXISTS (
+		SELECT 1 FROM bookmarks WHERE bookmarks.garbage_id = garbages.id AND bookmarks.user_id = NULLIF($1, '')::uuid
+	) AS bookmarked`
 
-	sessions = scs.New()
-	sessions.Lifetime = time.Duration(24 * time.Hour * 365)
+	// followingColumn selects whether the user whose ID is the query's first argument follows each garbage's owner
+	followingColumn = `EXISTS (
+		SELECT 1 FROM follows WHERE follows.followee_id = garbages.owner_id AND follows.follower_id = NULLIF($1, '')::uuid
+	) AS following`
 
-	sessionStore = pgxstore.New(db)
+	// reactionsColumn selects the types of reaction the user whose ID is the query's first argument reacted to each
+	// garbage with
+	reactionsColumn = `ARRAY(
+		SELECT type FROM reactions WHERE reactions.garbage_id = garbages.id AND reactions.user_id = NULLIF($1, '')::uuid
+	) AS reactions`
 
-	sessions.Cookie.Name = "session_id"
-	sessions.Cookie.HttpOnly = true
-	sessions.Cookie.Persist = true
-	sessions.Cookie.SameSite = http.SameSiteNoneMode
-	sessions.Cookie.Secure = true
-	sessions.Store = sessionStore
+	// duplicateSimilarity is the minimum trigram similarity between the normalized content of two garbage posts for
+	// them to be considered possible duplicates
+	duplicateSimilarity = 0.5
 
-	ctx := context.Background()
-	NQ, err = neoq.New(ctx,
-		neoq.WithBackend(postgres.Backend),
-		postgres.WithConnectionString(os.Getenv("POSTGRES_URL")),
-		postgres.WithTransactionTimeout(1000*60*5),
-	)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to initialize background worker: %v\n", err)
-		os.Exit(1)
-	}
+	// readTimeout is how long clients may take to send a request, including its body
+	readTimeout = 15 * time.Second
 
-	err = NQ.Start(ctx, handler.New("welcome_email", welcomeEmailHandler))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to initialize welcome email handler: %v\n", err)
-		os.Exit(1)
-	}
+	// writeTimeout is how long handlers may take to write their responses. Link previews are fetched while garbage is
+	// being created, so it must be longer than their fetch timeout. Event streams set their own deadlines
+	writeTimeout = 30 * time.Second
 
-	md = goldmark.New(
-		goldmark.WithExtensions(extension.GFM),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			gmhtml.WithHardWraps(),
-		),
-	)
-}
+	// idleTimeout is how long keep-alive connections are kept open between requests
+	idleTimeout = 2 * time.Minute
 
-func main() {
-	defer db.Close()
-	defer sessionStore.StopCleanup()
-	defer NQ.Shutdown(context.Background())
+	// maxHeaderBytes is the largest that request headers may be
+	maxHeaderBytes = 64 << 10
 
-	serverRoot, _ := fs.Sub(publicFS, "public")
-	staticContentServer := http.FileServer(http.FS(serverRoot))
+	// readinessTimeout is how long readiness checks may wait on Postgres
+	readinessTimeout = 2 * time.Second
 
-	r := chi.NewRouter()
-	r.Use(sessions.LoadAndSave)
-	r.Use(middleware.Logger)
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Recoverer)
-	r.Use(cors.Handler(cors.Options{
-		AllowCredentials: true,
-		AllowedOrigins:   []string{"http://localhost:1313", fmt.Sprintf("https://%s", os.Getenv("SITE_DOMAIN"))},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Cookie", "Authorization", "Content-Type", "X-CSRF-Token", "hx-target", "hx-current-url", "hx-request", "hx-trigger", "hx-trigger-name", "hx-boosted"},
-		ExposedHeaders:   []string{"Link", "HX-Location", "Vary", "Access-Control-Allow-Origin"},
-		MaxAge:           300, // Maximum value not ignored by any of major browsers
-	}))
+	// descriptionLength is the longest, in characters, that garbage descriptions shown in search results and link
+	// previews may be
+	descriptionLength = 160
+)
 
-	// Add any number of handlers for custom endpoints here
-	r.Route("/", func(r chi.Router) {
-		// any requests for which there are no defined chi routes are sent to the "file system"
-		// server, serving static Hugo content
-		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
-			staticContentServer.ServeHTTP(w, r)
-		})
+// commit and buildTime identify the build. They're set with -ldflags at build time, e.g.
+// go build -ldflags "-X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	commit    = ""
+	buildTime = ""
+)
 
-		r.Get("/nav/user_items", navUserItems)
-		r.Route("/users", func(users chi.Router) {
-			users.Post("/new_user_validation", newUserValidationHandler)
-			users.Get("/create", creatAccountPageHandler)
-			users.Post("/login", loginHandler)
-			users.Post("/create", createAccountHandler)
-			users.Get("/logout", logoutHandler)
-			users.Get("/email_verification/{uev_id}", emailVerification)
-		})
-		r.Route("/garbage", func(garbage chi.Router) {
-			garbage.Get("/list", listGarbageHandler)
-			garbage.Post("/new", createGarbageHandler)
-			garbage.Get("/{garbage_id}/edit", editGarbageHandler)
-			garbage.Put("/{garbage_id}", editGarbageUpdateHandler)
-			garbage.Get("/{garbage_id}", showGarbageHandler)
-			garbage.Put("/{garbage_id}/uplevel", addUplevelHandler)
-			garbage.Get("/{garbage_id}/uplevel", getUplevelHandler)
-		})
-	})
+// Store is the database in which the server keeps its data. *pgxpool.Pool is a Store
+type Store interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
 
-	// this environment variable is present in production
-	// if the name of the port in job.nomad.hcl changes, this port
-	// name will need to change as well
-	port := os.Getenv("NOMAD_HOST_PORT_garbage_speak")
-	if port == "" {
-		port = "1314"
-	}
+// Queue queues background jobs. neoq.Neoq is a Queue
+type Queue interface {
+	Enqueue(ctx context.Context, job *jobs.Job) (jobID string, err error)
+}
 
-	addr := fmt.Sprintf("%s:%s", "0.0.0.0", port)
+// Mailer sends email
+type Mailer interface {
+	// Send sends a plain text email to recipient. headers are added to the email's To, From, and Subject headers.
+	Send(recipient, subject, body string, headers map[string]string) error
+}
 
-	fmt.Println("Starting API server on", addr)
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatal(err)
-	}
+// Renderer renders garbage's markdown content
+type Renderer interface {
+	// Render renders markdown as HTML that's safe for templates to include without escaping
+	Render(markdown string) (html string)
 }
 
-func getUplevelHandler(w http.ResponseWriter, r *http.Request) {
-	garbageID := chi.URLParam(r, "garbage_id")
+// LinkFetcher fetches the previews of links in garbage. *link_preview.Fetcher is a LinkFetcher
+type LinkFetcher interface {
+	Fetch(ctx context.Context, rawURL string) (preview link_preview.Preview, err error)
+}
 
-	var uplevel int
-	db.QueryRow(r.Context(), "SELECT COUNT(*) FROM uplevels WHERE garbage_id = $1", garbageID).Scan(&uplevel)
+// Redactor redacts the names of colleagues and companies from garbage. *redactor.Redactor is a Redactor
+type Redactor interface {
+	Redact(content string) (result redactor.Result)
+}
 
-	w.Write([]byte(strconv.Itoa(uplevel)))
-	w.WriteHeader(http.StatusOK)
+// Broker streams garbage events to clients. *events.Broker is a Broker
+type Broker interface {
+	http.Handler
+	// Listen publishes an event for each notification on the given Postgres channel until ctx is done
+	Listen(ctx context.Context, pool *pgxpool.Pool, channel string, toEvent func(payload string) (events.Event, error))
+	// Close disconnects every client
+	Close()
 }
 
-func addUplevelHandler(w http.ResponseWriter, r *http.Request) {
-	garbageID := chi.URLParam(r, "garbage_id")
-	userID := sessions.GetString(r.Context(), "userID")
+// Metrics records the server's metrics. *metrics.Metrics is a Metrics
+type Metrics interface {
+	// Handler serves the metrics
+	Handler() http.Handler
+	// Middleware observes the duration of every request
+	Middleware(next http.Handler) http.Handler
+	// Job wraps a job handler, counting its runs on queue by outcome
+	Job(queue string, handle func(ctx context.Context) error) func(ctx context.Context) error
+	// CollectPoolStats collects the statistics of the database connection pool
+	CollectPoolStats(pool *pgxpool.Pool)
+	// EmailFailed counts a failure to send email, e.g. "welcome"
+	EmailFailed(email string)
+	// SignedUp counts a user signing up
+	SignedUp()
+	// Posted counts garbage being published
+	Posted()
+	// Reacted counts a reaction to garbage, e.g. "uplevel"
+	Reacted(reactionType string)
+}
 
-	if userID == "" {
-		ise(errors.New("not logged in"), w)
-		return
-	}
+// Templates renders HTML. *templates.Templates is a Templates
+type Templates interface {
+	// Render executes the named template from view with data, writing its output to w
+	Render(ctx context.Context, w io.Writer, view, name string, data any) error
+	// Page renders a full page to w: the site's layout, with content as its main content
+	Page(ctx context.Context, w io.Writer, content, title string, meta ...html_parser.Meta) error
+}
 
-	ctx := context.Background()
-	_, err := db.Exec(ctx,
-		"INSERT INTO uplevels(garbage_id, user_id) VALUES ($1, $2)",
-		garbageID,
-		userID)
-	if err != nil {
-		var pgErr *pgconn.PgError
-		if errors.As(err, &pgErr) {
-			if pgErr.Code == "23505" { // duplicate key error
-				goto render
-			}
-		}
-		ise(err, w)
-		return
-	}
+// Server serves the garbage speak API and runs its background jobs
+type Server struct {
+	config      app_config.Config
+	store       Store
+	sessions    *scs.SessionManager
+	queue       Queue
+	mailer      Mailer
+	renderer    Renderer
+	linkFetcher LinkFetcher
+	redactor    Redactor
+	broker      Broker
+	metrics     Metrics
+	templates   Templates
+	jobsStarted atomic.Bool // whether background jobs have started, for readiness checks
+
+	// reactionTypes are the reactions users may have to garbage in addition to uplevels
+	reactionTypes []ReactionType
+}
 
-render:
-	garbageUUID, _ := uuid.FromString(garbageID)
-	garbage := Garbage{ID: garbageUUID}
-	tmpl := template.Must(template.ParseFS(partialsFS, "partials/garbage/uplevel_button.tmpl"))
-	err = tmpl.ExecuteTemplate(w, "uplevel_button.tmpl", map[string]any{
-		"Garbage":    garbage,
-		"ApiBaseUrl": apiURL(),
-		"UserID":     userID,
-	})
-	if err != nil {
-		ise(err, w)
-		return
-	}
+// ServerOption configures a Server's optional dependencies, which are otherwise created by NewServer
+type ServerOption func(*Server)
 
-	w.WriteHeader(http.StatusOK)
+// WithLinkFetcher fetches link previews with fetcher
+func WithLinkFetcher(fetcher LinkFetcher) ServerOption {
+	return func(s *Server) { s.linkFetcher = fetcher }
 }
 
-func editGarbageUpdateHandler(w http.ResponseWriter, r *http.Request) {
-	garbageID := chi.URLParam(r, "garbage_id")
-	userID := sessions.GetString(r.Context(), "userID")
+// WithRedactor redacts garbage with redactor
+func WithRedactor(redactor Redactor) ServerOption {
+	return func(s *Server) { s.redactor = redactor }
+}
 
-	if userID == "" {
-		ise(errors.New("not logged in"), w)
-		return
-	}
+// WithBroker publishes garbage events to broker
+func WithBroker(broker Broker) ServerOption {
+	return func(s *Server) { s.broker = broker }
+}
 
-	if err := r.ParseForm(); err != nil {
-		ise(err, w)
-		return
-	}
+// WithMetrics records metrics with metrics
+func WithMetrics(metrics Metrics) ServerOption {
+	return func(s *Server) { s.metrics = metrics }
+}
 
-	url := r.PostForm.Get("url")
-	title := r.PostForm.Get("title")
-	content := r.PostForm.Get("garbage")
-	// TODO this is an arbitrary length that will likely need to change
-	if len(content) == 10 {
-		w.WriteHeader(400)
-		return
+// NewServer returns a Server that keeps its data in store, its sessions in sessions, queues background jobs on queue,
+// sends email with mailer, renders garbage with renderer, and renders HTML with templates
+func NewServer(config app_config.Config, store Store, sessions *scs.SessionManager, queue Queue, mailer Mailer, renderer Renderer, templates Templates, options ...ServerOption) *Server {
+	s := &Server{
+		config:        config,
+		store:         store,
+		sessions:      sessions,
+		queue:         queue,
+		mailer:        mailer,
+		renderer:      renderer,
+		templates:     templates,
+		reactionTypes: defaultReactionTypes,
 	}
 
-	renderedContent := mdToHtml(content)
-	metadata := map[string]any{}
-	tags := r.Form["tags"]
-	if len(tags) > 0 {
-		metadata["tags"] = tags
+	if config.ReactionTypes != "" {
+		s.reactionTypes = parseReactionTypes(config.ReactionTypes)
 	}
 
-	ctx := context.Background()
-	_, err := db.Exec(ctx,
-		"UPDATE garbages SET (title, content, rendered_content, url, metadata) = ($1, $2, $3, $4, $5) WHERE id = $6",
-		title,
-		content,
-		renderedContent,
-		url,
-		metadata,
-		garbageID)
-	if err != nil {
-		ise(err, w)
-		return
+	for _, option := range options {
+		option(s)
 	}
 
-	w.Header().Add("hx-location", appURL())
-}
-
-func editGarbageHandler(w http.ResponseWriter, r *http.Request) {
-	garbageID := chi.URLParam(r, "garbage_id")
-	userID := sessions.GetString(r.Context(), "userID")
-
-	garbage := Garbage{}
-	ctx := context.Background()
-	err := pgxscan.Get(
-		ctx,
-		db,
-		&garbage,
-		`SELECT id, owner_id, title, content, rendered_content, metadata, url FROM garbages WHERE id = $1 AND owner_id = $2`, garbageID, userID)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+	if s.linkFetcher == nil {
+		s.linkFetcher = link_preview.NewFetcher()
 	}
 
-	if userID == "" || userID != garbage.OwnerID.String() {
-		w.WriteHeader(http.StatusUnauthorized)
-		return
+	if s.redactor == nil {
+		s.redactor = redactor.New(config.RedactionColleagues, config.RedactionCompanies)
 	}
 
-	availableTags := []string{
-		"Nouned verb",
-		"Verbed noun",
-		"Nouned adjective",
-		"Novel garbage",
-		"Standard-issue garbage",
-	}
-	selectedTags := map[string]bool{}
-	if tags, ok := garbage.Metadata["tags"].([]interface{}); ok {
-		for _, tag := range tags {
-			selectedTags[tag.(string)] = true
-		}
+	if s.broker == nil {
+		s.broker = events.NewBroker()
 	}
 
-	tmplVars := map[string]any{
-		"ApiBaseUrl":    apiURL(),
-		"Garbage":       garbage,
-		"SelectedTags":  selectedTags,
-		"AvailableTags": availableTags,
-	}
-	tmpl := template.Must(template.ParseFS(partialsFS, "partials/garbage/edit.html"))
-	err = tmpl.ExecuteTemplate(w, "edit.html", tmplVars)
-	if err != nil {
-		ise(err, w)
-		return
+	if s.metrics == nil {
+		s.metrics = metrics.New()
 	}
 
-	w.Header().Add("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
+	return s
 }
 
-// newUserValidationHandler checks whether a username is available during account creation
-func newUserValidationHandler(w http.ResponseWriter, r *http.Request) {
-	tmplVars := map[string]any{"ApiBaseUrl": apiURL()}
-	tmpl := template.Must(template.ParseFS(partialsFS, "partials/users/new_user_validation.html"))
-
-	errCnt := 0
-
-	if err := r.ParseForm(); err != nil {
-		ise(err, w)
-		return
+// StartJobs starts processing the server's background jobs with nq
+func (s *Server) StartJobs(ctx context.Context, nq neoq.Neoq) (err error) {
+	job := func(queue string, handle handler.Func) handler.Handler {
+		return handler.New(queue, s.metrics.Job(queue, tracing.Job(queue, logging.Job(queue, handle))))
 	}
 
-	username := r.PostForm.Get("username")
-	email := r.PostForm.Get("email")
-	password := r.PostForm.Get("password")
-	passwordConfirmation := r.PostForm.Get("password_confirmation")
-
-	tmplVars["Username"] = username
-	tmplVars["Email"] = email
-	tmplVars["Password"] = password
-	tmplVars["PasswordConfirmation"] = passwordConfirmation
-
-	if len(username) == 0 {
-		tmplVars["UsernameError"] = "Please choose a username"
-		errCnt += 1
-	} else if len(username) > 0 {
-		var userID string
-		db.QueryRow(r.Context(), "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
-		if userID != "" {
-			tmplVars["UsernameError"] = fmt.Sprintf("Username '%s' is unavailable. Choose a different username.", username)
-			errCnt += 1
-		}
+	err = nq.Start(ctx, job("welcome_email", s.welcomeEmailHandler))
+	if err != nil {
+		return fmt.Errorf("unable to initialize welcome email handler: %w", err)
 	}
 
-	if (len(email) > 0 && len(email) < 4) || (len(email) >= 4 && !strings.Contains(email, "@")) {
-		tmplVars["EmailError"] = "Please enter a valid email address."
-		errCnt += 1
+	err = nq.Start(ctx, job("rerender_garbage", s.rerenderGarbageHandler))
+	if err != nil {
+		return fmt.Errorf("unable to initialize garbage rerender handler: %w", err)
 	}
 
-	if len(password) > 0 && len(password) < 8 {
-		tmplVars["PasswordError"] = "Please choose a password greater than 8 characters"
-		errCnt += 1
+	// weekly digests are sent Mondays at 09:00 UTC
+	err = nq.StartCron(ctx, "0 0 9 * * 1", job("weekly_digest", s.weeklyDigestHandler))
+	if err != nil {
+		return fmt.Errorf("unable to initialize weekly digest handler: %w", err)
 	}
 
-	if len(password) > 0 && len(passwordConfirmation) > 0 && password != passwordConfirmation {
-		tmplVars["PasswordConfirmationError"] = "Passwords do not match"
-		errCnt += 1
+	err = nq.Start(ctx, job("weekly_digest_email", s.weeklyDigestEmailHandler))
+	if err != nil {
+		return fmt.Errorf("unable to initialize weekly digest email handler: %w", err)
 	}
 
-	tmplVars["ErrorCount"] = errCnt
-
-	err := tmpl.ExecuteTemplate(w, "new_user_validation.html", tmplVars)
+	err = nq.Start(ctx, job("notify_followers", s.notifyFollowersHandler))
 	if err != nil {
-		ise(err, w)
-		return
+		return fmt.Errorf("unable to initialize follower notification handler: %w", err)
 	}
 
-	w.Header().Add("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
-}
-
-// loginHandler handles login requests and performs validation
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		ise(err, w)
-		return
+	err = nq.Start(ctx, job("publish_garbage", s.publishGarbageHandler))
+	if err != nil {
+		return fmt.Errorf("unable to initialize garbage publishing handler: %w", err)
 	}
 
-	username := r.PostForm.Get("username")
-	password := r.PostForm.Get("password")
-
-	var userID string
-	var storedPasswordHash string
-
-	// this anti-join prevents users with pending user email verifications from logging in
-	antiJoinQuery := `SELECT users.id, password FROM users
-LEFT JOIN user_email_verifications ON user_email_verifications.user_id = users.id
-WHERE user_email_verifications.id IS NULL
-AND username = $1`
-
-	var err error
-	err = db.QueryRow(r.Context(), antiJoinQuery, username).Scan(&userID, &storedPasswordHash)
+	// garbage of the day is picked at the start of each day, UTC
+	err = nq.StartCron(ctx, "0 0 0 * * *", job("featured_garbage", s.featuredGarbagePickHandler))
 	if err != nil {
-		ise(err, w)
-		return
+		return fmt.Errorf("unable to initialize featured garbage handler: %w", err)
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(storedPasswordHash), []byte(password)); err == nil {
-		err = sessions.RenewToken(r.Context())
-		if err != nil {
-			ise(err, w)
-			return
-		}
-
-		sessions.Put(r.Context(), "userID", userID)
-		w.Header().Add("hx-location", appURL())
-
-		return
+	err = nq.Start(ctx, job("link_preview", s.linkPreviewHandler))
+	if err != nil {
+		return fmt.Errorf("unable to initialize link preview handler: %w", err)
 	}
 
-	tmpl := template.Must(template.ParseFS(partialsFS, "partials/users/login_validation.html"))
-	err = tmpl.ExecuteTemplate(w, "login_validation.html", map[string]any{
-		"ApiBaseURL": apiURL(),
-		"LoginError": "Incorrect username or password",
-		"Username":   username,
-		"Password":   password,
+	// jobs are fingerprinted by their payload, so only one rerender job is ever pending for a given render version
+	err = s.enqueue(ctx, &jobs.Job{
+		Queue:   "rerender_garbage",
+		Payload: map[string]any{"render_version": renderVersion},
 	})
-	if err != nil {
-		ise(err, w)
-		return
+	if err != nil && !errors.Is(err, postgres.ErrDuplicateJob) {
+		slog.ErrorContext(ctx, "unable to queue garbage rerender", "error", err)
 	}
 
-	w.Header().Add("Content-Type", "text/html")
-	w.WriteHeader(200)
-}
-
-// logoutHandler handles users logout requests
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
-	sessions.Destroy(r.Context())
+	s.jobsStarted.Store(true)
 
-	w.Header().Add("hx-location", appURL())
-	w.WriteHeader(http.StatusOK)
+	return nil
 }
 
-// navUserItems returns a nav items depending on whether the user is logged in
-func navUserItems(w http.ResponseWriter, r *http.Request) {
-	var tmpl *template.Template
-
-	var err error
-	tmpl = template.Must(template.ParseFS(partialsFS, "partials/nav/*.html"))
-	if isLoggedIn(r) {
-		err = tmpl.ExecuteTemplate(w, "user_nav_items.html", map[string]any{"ApiURL": apiURL()})
-	} else {
-		err = tmpl.ExecuteTemplate(w, "non_user_nav_items.html", map[string]any{"ApiURL": apiURL()})
-	}
-
-	if err != nil {
-		ise(err, w)
-		return
-	}
-
-	w.Header().Add("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
+// CollectPoolStats exposes the database pool's statistics as metrics
+func (s *Server) CollectPoolStats(pool *pgxpool.Pool) {
+	s.metrics.CollectPoolStats(pool)
 }
 
-// creatAccountPageHandler serves the new account form
-func creatAccountPageHandler(w http.ResponseWriter, r *http.Request) {
-	tmpl := template.Must(template.ParseFS(partialsFS, "partials/users/*"))
-	err := tmpl.ExecuteTemplate(w, "create.html", map[string]any{})
+// enqueue queues a job whose payload carries ctx's log and trace context, so that the job's log messages and spans can
+// be traced back to the request that queued it
+func (s *Server) enqueue(ctx context.Context, j *jobs.Job) (err error) {
+	// jobs with the same payload are duplicates, so jobs are fingerprinted before the log and trace context, which
+	// differ between requests, are added to their payload
+	err = jobs.FingerprintJob(j)
 	if err != nil {
-		ise(err, w)
 		return
 	}
 
-	w.Header().Add("Content-Type", "text/html")
-	w.WriteHeader(http.StatusOK)
+	j.Payload = logging.Payload(ctx, j.Payload)
+	j.Payload = tracing.Payload(ctx, j.Payload)
+	_, err = s.queue.Enqueue(ctx, j)
+	return
 }
 
-// emailVerification takes a UserEmailVerification ID, and if it exists, verifies the associated User account by
-// deleting the UEV record (user login's check if a UEV exists for a User before permitting login)
-func emailVerification(w http.ResponseWriter, r *http.Request) {
-	// user email verification id
-	uevID := chi.URLParam(r, "uev_id")
+// ListenForEvents publishes garbage events to the server's event stream until ctx is done
+func (s *Server) ListenForEvents(ctx context.Context, pool *pgxpool.Pool) {
+	s.broker.Listen(ctx, pool, "garbage_events", s.garbageEvent)
+}
 
-	tx, err := db.Begin(r.Context())
+// migrateDatabase runs all pending migrations. A database that's already up to date isn't an error.
+func migrateDatabase(postgresURL string) (err error) {
+	d, err := iofs.New(migrationsFS, "migrations")
 	if err != nil {
-		ise(err, w)
 		return
 	}
-	// Rollback is safe to call even if the tx is already closed, so if
-	// the tx commits successfully, this is a no-op
-	defer tx.Rollback(r.Context())
-
-	var userID string
-	err = tx.QueryRow(r.Context(), "DELETE FROM user_email_verifications WHERE id = $1 RETURNING user_id", uevID).Scan(&userID)
-	if err != nil {
-		goto render
-	}
 
-	err = sessions.RenewToken(r.Context())
+	m, err := migrate.NewWithSourceInstance("iofs", d, postgresURL)
 	if err != nil {
-		ise(err, w)
 		return
 	}
 
-	// create the user's session
-	sessions.Put(r.Context(), "userID", userID)
-
-	tx.Commit(r.Context())
-
-render:
-	http.Redirect(w, r, appURL(), http.StatusFound)
-}
-
-func mdToHtml(markdown string) (html string) {
-	var buf bytes.Buffer
-	if err := md.Convert([]byte(markdown), &buf); err != nil {
-		panic(err)
+	err = m.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		err = nil
 	}
 
-	html = buf.String()
 	return
 }
 
-func createGarbageHandler(w http.ResponseWriter, r *http.Request) {
-	if !isLoggedIn(r) {
-		w.Header().Add("hx-location", fmt.Sprintf("%s/users/login", appURL()))
-		return
-	}
-
-	userID := sessions.GetString(r.Context(), "userID")
-	if userID == "" {
-		ise(errors.New("not logged in"), w)
+// latestMigration returns the version of the newest migration, which is the version that the database is expected to
+// be migrated to
+func latestMigration() (version uint64, err error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.up.sql")
+	if err != nil {
 		return
 	}
 
-	if err := r.ParseForm(); err != nil {
-		ise(err, w)
-		return
-	}
+	for _, file := range files {
+		prefix, _, _ := strings.Cut(path.Base(file), "_")
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid migration name '%s': %w", file, err)
+		}
 
-	title := r.PostForm.Get("title")
-	content := r.PostForm.Get("garbage")
-	// TODO this is an arbitrary length that will likely need to change
-	if len(content) == 10 {
-		w.WriteHeader(400)
-		return
+		version = max(version, v)
 	}
 
-	renderedContent := mdToHtml(content)
-	url := r.PostForm.Get("url")
-
-	metadata := map[string]any{}
-	tags := r.Form["tags"]
-	if len(tags) > 0 {
-		metadata["tags"] = tags
-	}
+	return
+}
 
-	ctx := context.Background()
-	db.QueryRow(ctx,
-		"INSERT INTO garbages(title, content, rendered_content, url, metadata, owner_id) VALUES ($1, $2, $3, $4, $5, $6)",
-		title,
-		content,
-		renderedContent,
-		url,
-		metadata,
-		userID)
+// newSessionManager returns a session manager that keeps sessions in store
+func newSessionManager(store scs.Store) *scs.SessionManager {
+	sessions := scs.New()
+	sessions.Lifetime = time.Duration(24 * time.Hour * 365)
+	sessions.Cookie.Name = "session_id"
+	sessions.Cookie.HttpOnly = true
+	sessions.Cookie.Persist = true
+	sessions.Cookie.SameSite = http.SameSiteNoneMode
+	sessions.Cookie.Secure = true
+	sessions.Store = store
 
-	w.Header().Add("hx-location", appURL())
+	return sessions
 }
 
-// createAccountHandler creates new accounts
-func createAccountHandler(w http.ResponseWriter, r *http.Request) {
-	if err := r.ParseForm(); err != nil {
-		ise(err, w)
-		return
-	}
-
-	username := r.PostForm.Get("username")
-	if len(username) == 0 {
-		w.WriteHeader(400)
-		return
+func main() {
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "path to an optional TOML configuration file")
+	printConfig := flag.Bool("print-config", false, "print the configuration, with secrets redacted, and exit")
+	flag.Parse()
+
+	config, err := app_config.Load(*configFile)
+	slog.SetDefault(logging.New(os.Stderr, config.Level()))
+	if *printConfig {
+		if perr := config.Print(os.Stdout); perr != nil {
+			slog.Error("unable to print configuration", "error", perr)
+			os.Exit(1)
+		}
 	}
-
-	password := r.PostForm.Get("password")
-	if len(password) < 8 {
-		w.WriteHeader(400)
-		return
+	if err != nil {
+		slog.Error("invalid configuration", "errors", strings.Split(err.Error(), "\n"))
+		os.Exit(1)
 	}
-
-	email := r.PostForm.Get("email")
-	if !strings.Contains(email, "@") {
-		w.WriteHeader(400)
+	if *printConfig {
 		return
 	}
 
-	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	closeTracing, err := tracing.Setup(context.Background(), config.TraceExporter, config.TraceEndpoint, "garbage_speak", commit,
+		config.Env)
 	if err != nil {
-		ise(err, w)
-		return
+		slog.Error("unable to set up tracing", "error", err)
+		os.Exit(1)
 	}
 
-	ctx := context.Background()
-	tx, err := db.Begin(ctx)
-	if err != nil {
-		ise(err, w)
-		return
-	}
-	// Rollback is safe to call even if the tx is already closed, so if
-	// the tx commits successfully, this is a no-op
-	defer tx.Rollback(ctx)
+	postgresURL := config.PostgresURL
 
-	var userID string
-	err = tx.QueryRow(ctx, "insert into users(username, password, email) values ($1, $2, $3) returning id", username, passwordHash, email).Scan(&userID)
+	err = migrateDatabase(postgresURL)
 	if err != nil {
-		ise(err, w)
-		return
+		slog.Error("unable to migrate database", "error", err)
+		os.Exit(1)
 	}
 
-	var uevID string
-	err = tx.QueryRow(ctx, "insert into user_email_verifications(user_id) values ($1) returning id", userID).Scan(&uevID)
+	dbconfig, err := pgxpool.ParseConfig(postgresURL)
 	if err != nil {
-		ise(err, w)
-		return
+		slog.Error("unable to configure database", "error", err)
+		os.Exit(1)
+	}
+	dbconfig.ConnConfig.Tracer = tracing.QueryTracer{}
+	dbconfig.AfterConnect = func(_ context.Context, conn *pgx.Conn) error {
+		pgxuuid.Register(conn.TypeMap())
+		return nil
 	}
 
-	err = tx.Commit(ctx)
+	pool, err := pgxpool.NewWithConfig(context.Background(), dbconfig)
 	if err != nil {
-		ise(err, w)
-		return
+		slog.Error("unable to connect to database", "error", err)
+		os.Exit(1)
 	}
 
-	_, err = NQ.Enqueue(ctx, &jobs.Job{
-		Queue: "welcome_email",
-		Payload: map[string]interface{}{
-			"recipient":        email,
-			"verification_url": fmt.Sprintf("%s/users/email_verification/%s", apiURL(), uevID),
-		},
-	})
+	sessionStore := pgxstore.New(pool)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nq, err := neoq.New(ctx,
+		neoq.WithBackend(postgres.Backend),
+		postgres.WithConnectionString(postgresURL),
+		postgres.WithTransactionTimeout(1000*60*5),
+	)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "unable to queue email veritifcation: %v", err)
-		return
+		slog.Error("unable to initialize background worker", "error", err)
+		os.Exit(1)
 	}
+	nq.SetLogger(slog.Default().With("component", "neoq"))
 
-	tmpl := template.Must(template.ParseFS(partialsFS, "partials/users/*"))
-	err = tmpl.ExecuteTemplate(w, "created.html", map[string]any{"Email": email})
+	tmpl, err := newTemplates(config)
 	if err != nil {
-		ise(err, w)
-		return
+		slog.Error("unable to parse templates", "error", err)
+		os.Exit(1)
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
-
-// argsfn is a template function to pass arbitrary template variables into sub-templates
-func argsfn(kvs ...interface{}) (map[string]interface{}, error) {
-	if len(kvs)%2 != 0 {
-		return nil, errors.New("args requires even number of arguments")
+	mailer := &smtpMailer{
+		host:     config.SMTPHost,
+		username: config.SMTPUsername,
+		password: config.SMTPPassword,
+		from:     config.Sender(),
 	}
-	m := make(map[string]interface{})
-	for i := 0; i < len(kvs); i += 2 {
-		s, ok := kvs[i].(string)
-		if !ok {
-			return nil, errors.New("even args to args must be strings")
-		}
-		m[s] = kvs[i+1]
-	}
-	return m, nil
-}
 
-// pagedQuery returns a paged query for the given query
-func pagedQuery(r *http.Request, query string) (pagedQuery string, firstItem *int) {
-	firstItemStr := r.URL.Query().Get("first_item")
+	server := NewServer(config, pool, newSessionManager(sessionStore), nq, mailer, newMarkdownRenderer(), tmpl)
 
-	var err error
-	var fi int
-	fi, err = strconv.Atoi(firstItemStr)
+	err = server.StartJobs(ctx, nq)
 	if err != nil {
-		pagedQuery = query + " ORDER BY n DESC LIMIT $1"
-		return
+		slog.Error("unable to start background jobs", "error", err)
+		os.Exit(1)
 	}
-	firstItem = &fi
 
-	// newer items have a larger n, since n monotonically increases
-	// hence we filter where n < our first item's n
-	pagedQuery = query + " WHERE n < $2"
-	pagedQuery = pagedQuery + " ORDER BY n DESC LIMIT $1"
+	go server.ListenForEvents(ctx, pool)
+	server.CollectPoolStats(pool)
 
-	return
-}
+	addr := fmt.Sprintf("%s:%d", "0.0.0.0", config.Port)
+	httpServer := server.HTTPServer(addr)
 
-// listGarbageHandler returns the latest garbage
-func listGarbageHandler(w http.ResponseWriter, r *http.Request) {
-	userID := sessions.GetString(r.Context(), "userID")
+	metricsAddr := fmt.Sprintf("%s:%d", "0.0.0.0", config.MetricsPort)
+	metricsServer := server.MetricsServer(metricsAddr)
 
-	query := `SELECT
-			garbages.id, n, owner_id, username, title, rendered_content, metadata, url, garbages.created_at
-			FROM garbages
-			JOIN users ON garbages.owner_id = users.id`
-	pagedQuery, firstItem := pagedQuery(r, query)
+	// Nomad sends SIGTERM when stopping the task, and SIGKILL once its kill_timeout elapses
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	ctx := context.Background()
-	garbage := []*Garbage{}
-	var err error
-	if firstItem != nil {
-		err = pgxscan.Select(ctx, db, &garbage, pagedQuery, pageSize, firstItem)
-	} else {
-		err = pgxscan.Select(ctx, db, &garbage, pagedQuery, pageSize)
-	}
+	serveErr := make(chan error, 2)
+	go func() {
+		slog.Info("starting API server", "addr", addr)
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	go func() {
+		slog.Info("starting metrics server", "addr", metricsAddr)
+		serveErr <- metricsServer.ListenAndServe()
+	}()
 
-	if err != nil {
-		ise(err, w)
-		return
+	exitCode := 0
+	select {
+	case <-signals.Done():
+		slog.Info("shutting down API server")
+	case err = <-serveErr:
+		slog.Error("API server failed", "error", err)
+		exitCode = 1
 	}
 
-	tmpl := template.Must(
-		template.New("list.html").
-			Funcs(template.FuncMap{"argsfn": argsfn}).
-			ParseFS(partialsFS, "partials/garbage/list.html", "partials/garbage/*.tmpl"))
-
-	// pagination
-	var lastItem *int
-	il := len(garbage) - 1
-	if il >= 0 {
-		lastItem = &(garbage[il].N)
+	if !shutdown(config.ShutdownTimeout, []*http.Server{httpServer, metricsServer}, nq, cancel, sessionStore, pool, closeTracing) {
+		exitCode = 1
 	}
 
-	buff := bytes.NewBufferString("")
-	err = tmpl.ExecuteTemplate(buff, "list.html", map[string]any{
-		"Posts":      garbage,
-		"ApiBaseUrl": apiURL(),
-		"LoggedIn":   isLoggedIn(r),
-		"UserID":     userID,
-		"LastItem":   lastItem,
-	})
-	if err != nil {
-		ise(err, w)
-		return
-	}
+	os.Exit(exitCode)
+}
 
-	if isPartialRequest(r) {
-		w.Write(buff.Bytes())
-	} else {
-		indexFile, _ := publicFS.Open("public/index.html")
-		content := html_parser.ParseAndSplice(indexFile, "content", buff.String())
-		w.Write([]byte(content))
+// shutdown stops the server in order: it stops accepting requests and waits for in-flight requests to finish, stops
+// background jobs, stops listening for events, closes the database pool, and finally flushes spans. It returns false if
+// the server didn't stop cleanly before timeout elapsed.
+func shutdown(timeout time.Duration, httpServers []*http.Server, nq neoq.Neoq, cancel context.CancelFunc, sessionStore *pgxstore.PostgresStore, pool *pgxpool.Pool, closeTracing func(context.Context) error) (ok bool) {
+	ctx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+
+	ok = true
+	for _, httpServer := range httpServers {
+		err := httpServer.Shutdown(ctx)
+		if err != nil {
+			slog.Error("unable to finish in-flight requests", "addr", httpServer.Addr, "error", err)
+			ok = false
+		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-}
+	nq.Shutdown(ctx)
+	cancel()
+	sessionStore.StopCleanup()
 
-func isPartialRequest(r *http.Request) bool {
-	return r.Header.Get("hx-request") != ""
-}
+	// closing the pool waits for every connection to be released, which may never happen if a job is stuck
+	closed := make(chan struct{})
+	go func() {
+		pool.Close()
+		close(closed)
+	}()
 
-// showGarbageHandler returns the latest garbage
-func showGarbageHandler(w http.ResponseWriter, r *http.Request) {
-	garbageID := chi.URLParam(r, "garbage_id")
-	userID := sessions.GetString(r.Context(), "userID")
-	ctx := context.Background()
-	garbage := Garbage{}
-	err := pgxscan.Get(
-		ctx,
-		db,
-		&garbage,
-		`SELECT garbages.id, owner_id, username, title, rendered_content, metadata, url, garbages.created_at
-			FROM garbages
-			JOIN users ON garbages.owner_id = users.id
-			WHERE garbages.id = $1`, garbageID)
-	if err != nil {
-		ise(err, w)
-		return
+	select {
+	case <-closed:
+	case <-ctx.Done():
+		slog.Error("unable to close database pool", "error", ctx.Err())
+		ok = false
 	}
 
-	buff := bytes.NewBufferString("")
-	tmpl := template.Must(
-		template.New("list.html").
-			Funcs(template.FuncMap{"argsfn": argsfn}).
-			ParseFS(partialsFS, "partials/garbage/show.tmpl", "partials/garbage/*.tmpl"))
-	err = tmpl.ExecuteTemplate(buff, "show.tmpl", map[string]any{
-		"Garbage":    garbage,
-		"ApiBaseUrl": apiURL(),
-		"LoggedIn":   isLoggedIn(r),
-		"UserID":     userID,
-	})
+	err := closeTracing(ctx)
 	if err != nil {
-		ise(err, w)
-		return
-	}
-
-	if isPartialRequest(r) {
-		w.Write(buff.Bytes())
-	} else {
-		indexFile, _ := publicFS.Open("public/index.html")
-		content := html_parser.ParseAndSplice(indexFile, "content", buff.String())
-		w.Write([]byte(content))
+		slog.Error("unable to flush spans", "error", err)
+		ok = false
 	}
 
-	w.WriteHeader(http.StatusOK)
+	return
 }
 
-// sendWelcomeEmail sends an email to recipient containing a special URL that only that can know, for the purpose of
-// email address verification
-func sendWelcomeEmail(recipient, verificationURL, siteName string) error {
-	smtpHost := os.Getenv("SMTP_HOST")
-	from := fmt.Sprintf("noreply@%s", os.Getenv("SITE_DOMAIN"))
-
-	msg := []byte(fmt.Sprintf("To: %s\r\n", recipient) +
-		fmt.Sprintf("From: %s", from) + "\r\n" +
-		fmt.Sprintf("Subject: Welcome to %s!\r\n", siteName) + "\r\n" +
-		fmt.Sprintf("Verify your email address by visiting: %s\r\n", verificationURL))
+// HTTPServer returns an HTTP server that serves the server's routes on addr. Event streams are closed when the HTTP
+// server shuts down, so that they don't hold up the shutdown.
+func (s *Server) HTTPServer(addr string) *http.Server {
+	httpServer := &http.Server{
+		Addr:           addr,
+		Handler:        s.Router(),
+		ReadTimeout:    readTimeout,
+		WriteTimeout:   writeTimeout,
+		IdleTimeout:    idleTimeout,
+		MaxHeaderBytes: maxHeaderBytes,
+	}
+	httpServer.RegisterOnShutdown(s.broker.Close)
 
-	host, _, _ := net.SplitHostPort(smtpHost)
+	return httpServer
+}
 
-	auth := smtp.PlainAuth("", os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), host)
+// MetricsServer returns an HTTP server that serves Prometheus metrics on addr. Metrics are served apart from the API, on
+// an internal port, so that they aren't public.
+func (s *Server) MetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.metrics.Handler())
 
-	// Here is the key, you need to call tls.Dial instead of smtp.Dial
-	// for smtp servers running on 465 that require an ssl connection
-	// from the very beginning (no starttls)
-	c, err := smtp.Dial(smtpHost)
-	if err != nil {
-		log.Panic(err)
+	return &http.Server{
+		Addr:           addr,
+		Handler:        mux,
+		ReadTimeout:    readTimeout,
+		WriteTimeout:   writeTimeout,
+		IdleTimeout:    idleTimeout,
+		MaxHeaderBytes: maxHeaderBytes,
 	}
+}
 
-	// TLS config
-	tlsconfig := &tls.Config{
-		InsecureSkipVerify: true,
-		ServerName:         host,
-	}
-	c.StartTLS(tlsconfig)
+// Router returns the handler that routes requests to the server's handlers
+func (s *Server) Router() http.Handler {
+	serverRoot, _ := fs.Sub(publicFS, "public")
+	staticContentServer := http.FileServer(http.FS(serverRoot))
 
-	// Auth
-	if err = c.Auth(auth); err != nil {
-		log.Panic(err)
-	}
+	r := chi.NewRouter()
+	r.Use(middleware.RequestID)
+	r.Use(middleware.RealIP)
+	r.Use(tracing.Middleware)
+	r.Use(logging.Middleware)
+	r.Use(s.metrics.Middleware)
+	r.Use(middleware.Recoverer)
+	r.Use(cors.Handler(cors.Options{
+		AllowCredentials: true,
+		AllowedOrigins:   []string{"http://localhost:1313", fmt.Sprintf("https://%s", s.config.SiteDomain)},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Cookie", "Authorization", "Content-Type", "X-CSRF-Token", "hx-target", "hx-current-url", "hx-request", "hx-trigger", "hx-trigger-name", "hx-boosted"},
+		ExposedHeaders:   []string{"Link", "HX-Location", "HX-Retarget", "HX-Reswap", "Vary", "Access-Control-Allow-Origin"},
+		MaxAge:           300, // Maximum value not ignored by any of major browsers
+	}))
 
-	// From
-	if err = c.Mail(from); err != nil {
-		log.Panic(err)
-	}
+	// the event stream is long-lived, and the session middleware buffers responses until their handlers return, so
+	// events are served outside of it
+	r.Get("/events", s.broker.ServeHTTP)
 
-	// Recipient
-	if err = c.Rcpt(recipient); err != nil {
-		log.Panic(err)
-	}
+	// health checks are made by Nomad, which has no session
+	r.Get("/healthz", s.healthzHandler)
+	r.Get("/readyz", s.readyzHandler)
+	r.Get("/version", s.versionHandler)
 
-	// Data
-	w, err := c.Data()
-	if err != nil {
-		log.Panic(err)
-	}
+	// Add any number of handlers for custom endpoints here
+	r.Route("/", func(r chi.Router) {
+		r.Use(s.sessions.LoadAndSave)
+		r.Use(s.logUserID)
 
-	_, err = w.Write(msg)
-	if err != nil {
-		log.Panic(err)
-	}
+		// any requests for which there are no defined chi routes are sent to the "file system"
+		// server, serving static Hugo content
+		r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+			staticContentServer.ServeHTTP(w, r)
+		})
 
-	err = w.Close()
-	if err != nil {
-		log.Panic(err)
-	}
+		r.Get("/nav/user_items", s.navUserItems)
+		r.Route("/notifications", func(notifications chi.Router) {
+			notifications.Get("/", s.listNotificationsHandler)
+			notifications.Put("/read", s.readAllNotificationsHandler)
+			notifications.Put("/preferences", s.updateNotificationPreferencesHandler)
+			notifications.Put("/{notification_id}/read", s.readNotificationHandler)
+		})
+		r.Route("/users", func(users chi.Router) {
+			users.Post("/new_user_validation", s.newUserValidationHandler)
+			users.Get("/create", s.creatAccountPageHandler)
+			users.Post("/login", s.loginHandler)
+			users.Post("/create", s.createAccountHandler)
+			users.Get("/logout", s.logoutHandler)
+			users.Get("/email_verification/{uev_id}", s.emailVerification)
+			users.Get("/settings", s.settingsHandler)
+			users.Put("/settings", s.updateSettingsHandler)
+			users.Get("/{user_id}/unsubscribe/{signature}", s.unsubscribePageHandler)
+			users.Get("/{user_id}", s.profileHandler)
+			users.Put("/{user_id}/follow", s.followHandler)
+			users.Delete("/{user_id}/follow", s.unfollowHandler)
+			users.Post("/{user_id}/unsubscribe/{signature}", s.unsubscribeHandler)
+		})
+		r.Route("/garbage", func(garbage chi.Router) {
+			garbage.Get("/list", s.listGarbageHandler)
+			garbage.Get("/drafts", s.listDraftsHandler)
+			garbage.Get("/featured", s.featuredGarbageHandler)
+			garbage.Get("/featured/archive", s.featuredArchiveHandler)
+			garbage.Post("/new", s.createGarbageHandler)
+			// previews are requested as users type, so limit how often any one client may render markdown
+			garbage.With(httprate.LimitByIP(previewRateLimit, time.Minute)).Post("/preview", s.previewGarbageHandler)
+			garbage.Get("/{garbage_id}/edit", s.editGarbageHandler)
+			garbage.Put("/{garbage_id}", s.editGarbageUpdateHandler)
+			garbage.Get("/{garbage_id}", s.showGarbageHandler)
+			garbage.Post("/{garbage_id}/merge", s.mergeGarbageHandler)
+			garbage.Post("/{garbage_id}/feature", s.pinFeaturedGarbageHandler)
+			garbage.Post("/{garbage_id}/report", s.reportGarbageHandler)
+			garbage.Put("/{garbage_id}/uplevel", s.addUplevelHandler)
+			garbage.Delete("/{garbage_id}/uplevel", s.removeUplevelHandler)
+			garbage.Get("/{garbage_id}/uplevel", s.getUplevelHandler)
+			garbage.Put("/{garbage_id}/reactions/{reaction}", s.addReactionHandler)
+			garbage.Delete("/{garbage_id}/reactions/{reaction}", s.removeReactionHandler)
+			garbage.Put("/{garbage_id}/bookmark", s.addBookmarkHandler)
+			garbage.Delete("/{garbage_id}/bookmark", s.removeBookmarkHandler)
+			garbage.Get("/{garbage_id}/collections", s.garbageCollectionsHandler)
+			garbage.Put("/{garbage_id}/collections", s.updateGarbageCollectionsHandler)
+		})
+		r.Get("/bookmarks/", s.listBookmarksHandler)
+		r.Route("/collections", func(collections chi.Router) {
+			collections.Post("/", s.createCollectionHandler)
+			collections.Get("/{collection_id}", s.showCollectionHandler)
+			collections.Put("/{collection_id}", s.updateCollectionHandler)
+			collections.Delete("/{collection_id}", s.deleteCollectionHandler)
+			collections.Get("/{collection_id}/export", s.exportCollectionHandler)
+		})
+	})
 
-	c.Quit()
+	return r
+}
 
-	return nil
+// newTemplates returns the server's templates. In development, they're read from disk on every render, so that changes
+// to partials and the site's layout don't require restarting the server.
+func newTemplates(config app_config.Config) (*templates.Templates, error) {
+	var partials, layout fs.FS = partialsFS, publicFS
+	if config.IsDevelopment() {
+		partials = os.DirFS(".")
+		layout = os.DirFS(".")
+	}
+
+	return templates.New(templates.Config{
+		Partials:   partials,
+		Views:      views,
+		Funcs:      templateFuncs,
+		Layout:     layout,
+		LayoutPath: "public/index.html",
+		Reload:     config.IsDevelopment(),
+	})
 }
 
-// welcomeEmailHandler sends a welcome email to new users
-func welcomeEmailHandler(ctx context.Context) (err error) {
-	var j *jobs.Job
-	j, err = jobs.FromContext(ctx)
-	if err != nil {
-		log.Println("unable to process welcome email:", err)
+// writePage writes content rendered for r: as is for htmx requests, and as a full page otherwise
+func (s *Server) writePage(w http.ResponseWriter, r *http.Request, content *bytes.Buffer) {
+	s.writePageWithHead(w, r, content, "")
+}
+
+// writePageWithHead writes content rendered for r like writePage, titling full pages with title and adding meta to
+// their head
+func (s *Server) writePageWithHead(w http.ResponseWriter, r *http.Request, content *bytes.Buffer, title string, meta ...html_parser.Meta) {
+	if isPartialRequest(r) {
+		w.Write(content.Bytes())
 		return
 	}
-	recipient := j.Payload["recipient"].(string)
-	verificationURL := j.Payload["verification_url"].(string)
-	err = sendWelcomeEmail(recipient, verificationURL, "Garbage Speak")
-
-	return
-}
 
-// env returns the server's environment name, e.g. "development"
-func env() string {
-	e := os.Getenv("GO_ENV")
-	if e != "" {
-		return e
+	page := bytes.NewBufferString("")
+	err := s.templates.Page(r.Context(), page, content.String(), title, meta...)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
 	}
 
-	return "development"
+	w.Write(page.Bytes())
 }
 
-// appURL returns the site's base URL, e.g. http://localhost:1313 in development, https://<SITE_DOMAIN> in production
-func appURL() string {
-	if env() == "development" {
-		return fmt.Sprintf("http://%s", os.Getenv("SITE_HOST"))
-	}
-
-	addr := os.Getenv("SITE_DOMAIN")
-	if addr == "" {
-		log.Fatalf("SITE_DOMAIN is not set")
+// writeTemplate renders the named template of view to w. Nothing is written unless the template renders, so that
+// rendering errors may still be reported with renderError.
+func (s *Server) writeTemplate(ctx context.Context, w http.ResponseWriter, view, name string, data any) error {
+	buff := bytes.NewBufferString("")
+	err := s.templates.Render(ctx, buff, view, name, data)
+	if err != nil {
+		return err
 	}
 
-	return fmt.Sprintf("https://%s", addr)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buff.Bytes())
+	return nil
 }
 
-// apiURL returns the server's base URL, e.g. http://localhost:1314 in development, https://<API_HOST> in production
-func apiURL() string {
-	if env() == "development" {
-		return fmt.Sprintf("http://%s", os.Getenv("API_HOST"))
+// argsfn is a template function to pass arbitrary template variables into sub-templates
+func argsfn(kvs ...interface{}) (map[string]interface{}, error) {
+	if len(kvs)%2 != 0 {
+		return nil, errors.New("args requires even number of arguments")
 	}
-
-	addr := os.Getenv("SITE_DOMAIN")
-	if addr == "" {
-		log.Fatalf("SITE_DOMAIN is not set")
+	m := make(map[string]interface{})
+	for i := 0; i < len(kvs); i += 2 {
+		s, ok := kvs[i].(string)
+		if !ok {
+			return nil, errors.New("even args to args must be strings")
+		}
+		m[s] = kvs[i+1]
 	}
+	return m, nil
+}
 
-	return fmt.Sprintf("https://%s", addr)
+func isPartialRequest(r *http.Request) bool {
+	return r.Header.Get("hx-request") != ""
 }
 
-func ise(err error, w http.ResponseWriter) {
-	fmt.Fprintf(w, "error: %v", err)
-	w.WriteHeader(http.StatusInternalServerError)
+// logUserID adds the current user's ID to the log messages of requests made by logged in users, including the message
+// logged once the request has been served
+func (s *Server) logUserID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID := s.sessions.GetString(r.Context(), "userID"); userID != "" {
+			logging.AddToRequest(r.Context(), "user_id", userID)
+			r = r.WithContext(logging.With(r.Context(), "user_id", userID))
+		}
+
+		next.ServeHTTP(w, r)
+	})
 }
 
 func isLoggedIn(r *http.Request) bool {
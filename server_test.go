@@ -0,0 +1,1428 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/acaloiaro/garbage_speak/app_config"
+	"github.com/acaloiaro/garbage_speak/link_preview"
+	"github.com/acaloiaro/garbage_speak/metrics"
+	"github.com/acaloiaro/garbage_speak/templates"
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	pgxuuid "github.com/jackc/pgx-gofrs-uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// hostile is user-supplied input that executes script if it's rendered without escaping
+const hostile = `"><script>alert(1)</script><img src=x onerror=alert(1)>`
+
+// testLayout stands in for the site's layout, which is built by Hugo
+const testLayout = `<html><head><title>garbage speak</title></head><body><main id="content"></main></body></html>`
+
+// testTemplates returns the server's templates, parsed from disk, and a stand-in for the site's layout
+func testTemplates(t *testing.T) *templates.Templates {
+	t.Helper()
+
+	tmpl, err := templates.New(templates.Config{
+		Partials:   os.DirFS("."),
+		Views:      views,
+		Funcs:      templateFuncs,
+		Layout:     fstest.MapFS{"index.html": {Data: []byte(testLayout)}},
+		LayoutPath: "index.html",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tmpl
+}
+
+// hostileGarbage returns published garbage whose every user-supplied field is hostile
+func hostileGarbage() *Garbage {
+	now := time.Now()
+	rendered := newMarkdownRenderer().Render(hostile)
+
+	return &Garbage{
+		ID:              uuid.Must(uuid.NewV4()),
+		OwnerID:         uuid.Must(uuid.NewV4()),
+		Username:        hostile,
+		Title:           hostile,
+		Content:         hostile,
+		RenderedContent: &rendered,
+		RenderVersion:   renderVersion,
+		Url:             "javascript:alert(1)",
+		LinkPreview: &link_preview.Preview{
+			Title:       hostile,
+			Description: hostile,
+			Image:       "javascript:alert(1)",
+		},
+		Metadata:    map[string]any{"tags": []any{hostile}},
+		CreatedAt:   now,
+		PublishedAt: &now,
+	}
+}
+
+func TestTemplatesEscapeUserInput(t *testing.T) {
+	tmpl := testTemplates(t)
+	garbage := hostileGarbage()
+
+	tests := []struct {
+		name string
+		view string
+		tmpl string
+		data map[string]any
+	}{
+		{
+			name: "list",
+			view: "garbage",
+			tmpl: "list.html",
+			data: map[string]any{
+				"Posts":         []*Garbage{garbage},
+				"UserID":        uuid.Must(uuid.NewV4()).String(),
+				"IsModerator":   true,
+				"ShowFeeds":     true,
+				"ReactionTypes": defaultReactionTypes,
+			},
+		},
+		{
+			name: "show",
+			view: "garbage",
+			tmpl: "show.tmpl",
+			data: map[string]any{
+				"Garbage":       garbage,
+				"UserID":        garbage.OwnerID.String(),
+				"IsModerator":   true,
+				"ReactionTypes": defaultReactionTypes,
+			},
+		},
+		{
+			name: "profile",
+			view: "users",
+			tmpl: "profile.html",
+			data: map[string]any{
+				"User":   &User{ID: garbage.OwnerID, Username: hostile},
+				"UserID": uuid.Must(uuid.NewV4()).String(),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := tmpl.Render(context.Background(), &buf, tt.view, tt.tmpl, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := buf.String()
+			for _, unsafe := range []string{"<script", "<img src=x", "javascript:"} {
+				if strings.Contains(out, unsafe) {
+					t.Errorf("output contains %q:\n%s", unsafe, out)
+				}
+			}
+
+			if !strings.Contains(out, "&lt;script&gt;") {
+				t.Errorf("output doesn't contain escaped input:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestMarkdownRendererSanitizes(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		unsafe   []string
+		want     string
+	}{
+		{
+			name:     "script",
+			markdown: "hello <script>alert(1)</script>",
+			unsafe:   []string{"<script"},
+			want:     "hello",
+		},
+		{
+			name:     "javascript link",
+			markdown: "[click me](javascript:alert(1))",
+			unsafe:   []string{"javascript:"},
+			want:     "click me",
+		},
+		{
+			name:     "javascript link with entities",
+			markdown: `<a href="jav&#x09;ascript:alert(1)">click me</a>`,
+			unsafe:   []string{"ascript:", "href"},
+		},
+		{
+			name:     "event handler attribute",
+			markdown: `<a href="https://example.com" onclick="alert(1)">click me</a>`,
+			unsafe:   []string{"onclick"},
+		},
+		{
+			name:     "event handler attribute on image",
+			markdown: `<img src="https://example.com/a.png" onerror="alert(1)">`,
+			unsafe:   []string{"onerror"},
+		},
+		{
+			name:     "markdown",
+			markdown: "**synergy**",
+			want:     "<strong>synergy</strong>",
+		},
+		{
+			name:     "task list",
+			markdown: "- [x] circle back",
+			want:     `type="checkbox"`,
+		},
+	}
+
+	renderer := newMarkdownRenderer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			html := renderer.Render(tt.markdown)
+
+			for _, unsafe := range tt.unsafe {
+				if strings.Contains(html, unsafe) {
+					t.Errorf("Render(%q) = %q, contains %q", tt.markdown, html, unsafe)
+				}
+			}
+
+			if !strings.Contains(html, tt.want) {
+				t.Errorf("Render(%q) = %q, want it to contain %q", tt.markdown, html, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderedHTMLSanitizesStaleContent(t *testing.T) {
+	unsanitized := `<p onclick="alert(1)">hello</p><script>alert(1)</script>`
+
+	tests := []struct {
+		name          string
+		content       *string
+		renderVersion int
+		want          string
+	}{
+		{
+			name: "not rendered",
+			want: "",
+		},
+		{
+			name:          "rendered by a stale pipeline",
+			content:       &unsanitized,
+			renderVersion: renderVersion - 1,
+			want:          "<p>hello</p>",
+		},
+		{
+			name:          "rendered by the current pipeline",
+			content:       &unsanitized,
+			renderVersion: renderVersion,
+			want:          unsanitized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := Garbage{RenderedContent: tt.content, RenderVersion: tt.renderVersion}
+			if got := string(g.RenderedHTML()); got != tt.want {
+				t.Errorf("RenderedHTML() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplatesConnectToEventsOnlyWhenLive(t *testing.T) {
+	tmpl := testTemplates(t)
+	garbage := hostileGarbage()
+
+	tests := []struct {
+		name            string
+		tmpl            string
+		data            map[string]any
+		wantConnections int
+		wantLiveCounts  bool
+	}{
+		{
+			name:            "live feed",
+			tmpl:            "list.html",
+			data:            map[string]any{"Posts": []*Garbage{garbage}, "Live": true},
+			wantConnections: 1,
+			wantLiveCounts:  true,
+		},
+		{
+			name: "list that isn't live",
+			tmpl: "list.html",
+			data: map[string]any{"Posts": []*Garbage{garbage}},
+		},
+		{
+			name:            "permalink",
+			tmpl:            "show.tmpl",
+			data:            map[string]any{"Garbage": garbage, "Live": true, "ConnectEvents": true},
+			wantConnections: 1,
+			wantLiveCounts:  true,
+		},
+		{
+			name:           "garbage fetched into a live feed",
+			tmpl:           "show.tmpl",
+			data:           map[string]any{"Garbage": garbage, "Live": true},
+			wantLiveCounts: true,
+		},
+		{
+			name: "featured",
+			tmpl: "featured.html",
+			data: map[string]any{"Garbage": garbage, "Date": time.Now()},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.data["ReactionTypes"] = defaultReactionTypes
+			tt.data["UserID"] = uuid.Must(uuid.NewV4()).String()
+
+			var buf bytes.Buffer
+			err := tmpl.Render(context.Background(), &buf, "garbage", tt.tmpl, tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out := buf.String()
+			if got := strings.Count(out, "sse-connect="); got != tt.wantConnections {
+				t.Errorf("output connects to events %d times, want %d:\n%s", got, tt.wantConnections, out)
+			}
+
+			if got := strings.Contains(out, `sse-swap="uplevel_count:`); got != tt.wantLiveCounts {
+				t.Errorf("uplevel counts are live = %v, want %v:\n%s", got, tt.wantLiveCounts, out)
+			}
+
+			if got := strings.Contains(out, "?live=true"); got != tt.wantLiveCounts {
+				t.Errorf("uplevel buttons stay live after upleveling = %v, want %v:\n%s", got, tt.wantLiveCounts, out)
+			}
+		})
+	}
+}
+
+func TestConfirmationToken(t *testing.T) {
+	confirmed := confirmationToken("Synergy", "Let's circle back", "https://example.com/")
+
+	tests := []struct {
+		name   string
+		fields []string
+		want   bool // whether the fields match what was confirmed
+	}{
+		{name: "unchanged", fields: []string{"Synergy", "Let's circle back", "https://example.com/"}, want: true},
+		{name: "changed title", fields: []string{"Synergy!", "Let's circle back", "https://example.com/"}},
+		{name: "changed content", fields: []string{"Synergy", "Let's circle back, Jane", "https://example.com/"}},
+		{name: "changed URL", fields: []string{"Synergy", "Let's circle back", "https://example.org/"}},
+		{name: "text moved between fields", fields: []string{"Synergy Let's", "circle back", "https://example.com/"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := confirmationToken(tt.fields...) == confirmed; got != tt.want {
+				t.Errorf("confirmationToken(%q) matches = %v, want %v", tt.fields, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactGarbageConfirmation(t *testing.T) {
+	config := app_config.Config{RedactionColleagues: []string{"Jane Doe"}}
+	s := NewServer(config, &fakeStore{}, scs.New(), &fakeQueue{}, &fakeMailer{}, fakeRenderer{}, testTemplates(t))
+
+	// redact submits garbage to redactGarbage, returning whether it may be saved and the confirmation it was shown
+	redact := func(content, confirmation string) (ok bool, shown string) {
+		r := httptest.NewRequest(http.MethodPost, "/garbage", strings.NewReader(url.Values{
+			"redactions_confirmed": {confirmation},
+		}.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+
+		w := httptest.NewRecorder()
+		_, _, ok = s.redactGarbage(w, r, "Synergy", content)
+		if match := regexp.MustCompile(`name="redactions_confirmed" value="([^"]*)"`).FindStringSubmatch(w.Body.String()); match != nil {
+			shown = match[1]
+		}
+
+		return
+	}
+
+	if ok, _ := redact("Let's circle back", ""); !ok {
+		t.Error("garbage that isn't redacted must be confirmed")
+	}
+
+	ok, shown := redact("Ask Jane Doe", "")
+	if ok || shown == "" {
+		t.Fatalf("redacted garbage wasn't shown for confirmation: ok = %v", ok)
+	}
+
+	if ok, _ := redact("Ask Jane Doe", shown); !ok {
+		t.Error("confirmed redactions weren't accepted")
+	}
+
+	if ok, _ := redact("Ask Jane Doe, or call 555-123-4567", shown); ok {
+		t.Error("redactions added after they were confirmed weren't shown for confirmation")
+	}
+
+	if ok, _ := redact("Ask Jane Doe", "true"); ok {
+		t.Error("redactions were accepted without their confirmation")
+	}
+}
+
+func TestWeeklyDigestRecordsOutcome(t *testing.T) {
+	const userID = "9a0e9ad3-5b6f-4c1d-8f0e-3f1c2b4a5d6e"
+
+	// upleveled returns last week's upleveled garbage
+	upleveled := func(values ...[]any) *fakeRows {
+		return &fakeRows{columns: []string{"id", "title", "uplevel_count"}, values: values}
+	}
+
+	tests := []struct {
+		name         string
+		row          []any
+		results      []*fakeRows
+		mailErr      error
+		wantErr      bool
+		wantSent     int
+		wantRecorded []string // the recorded outcomes
+	}{
+		{
+			name:         "sent",
+			row:          []any{"jane@garbagespeak.example"},
+			results:      []*fakeRows{upleveled([]any{uuid.Must(uuid.NewV4()), "Synergy", 3})},
+			wantSent:     1,
+			wantRecorded: []string{"sent"},
+		},
+		{
+			name:         "failed to send",
+			row:          []any{"jane@garbagespeak.example"},
+			results:      []*fakeRows{upleveled([]any{uuid.Must(uuid.NewV4()), "Synergy", 3})},
+			mailErr:      errors.New("mail server unavailable"),
+			wantErr:      true,
+			wantRecorded: []string{"failed"},
+		},
+		{
+			name:         "nothing upleveled",
+			row:          []any{"jane@garbagespeak.example"},
+			results:      []*fakeRows{upleveled()},
+			wantRecorded: []string{"skipped"},
+		},
+		{
+			name: "already sent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{row: tt.row, results: tt.results}
+			mailer := &fakeMailer{err: tt.mailErr}
+			s := NewServer(app_config.Config{SecretKey: "secret"}, store, scs.New(), &fakeQueue{}, mailer, fakeRenderer{},
+				testTemplates(t))
+
+			job := &jobs.Job{Queue: "weekly_digest_email", Payload: map[string]any{"user_id": userID, "week": "2026-10-19"}}
+			err := s.weeklyDigestEmailHandler(jobs.WithJobContext(context.Background(), job))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("weeklyDigestEmailHandler() error = %v, want error %t", err, tt.wantErr)
+			}
+
+			if len(mailer.recipients) != tt.wantSent {
+				t.Errorf("sent %d digests, want %d", len(mailer.recipients), tt.wantSent)
+			}
+
+			var recorded []string
+			for _, exec := range store.execs {
+				recorded = append(recorded, exec.args[2].(string))
+			}
+			if !slices.Equal(recorded, tt.wantRecorded) {
+				t.Errorf("recorded outcomes %v, want %v", recorded, tt.wantRecorded)
+			}
+		})
+	}
+}
+
+// testDB returns a connection pool to the migrated database at TEST_POSTGRES_URL, skipping the test if it's not set
+func testDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	postgresURL := os.Getenv("TEST_POSTGRES_URL")
+	if postgresURL == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	err := migrateDatabase(postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dbconfig, err := pgxpool.ParseConfig(postgresURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbconfig.AfterConnect = func(_ context.Context, conn *pgx.Conn) error {
+		pgxuuid.Register(conn.TypeMap())
+		return nil
+	}
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), dbconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+
+	return pool
+}
+
+// testUser creates a user, deleted along with everything it owns when the test finishes
+func testUser(t *testing.T, pool *pgxpool.Pool) (userID string) {
+	t.Helper()
+
+	ctx := context.Background()
+	name := "test_" + uuid.Must(uuid.NewV4()).String()[:8]
+	err := pool.QueryRow(ctx,
+		"INSERT INTO users(username, password, email) VALUES ($1, '', $2) RETURNING id",
+		name,
+		name+"@example.com").Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), "DELETE FROM users WHERE id = $1", userID)
+	})
+
+	return
+}
+
+// withSession returns r with a session in which userID is logged in, and URL parameters set as chi sets them
+func withSession(t *testing.T, sessions *scs.SessionManager, r *http.Request, userID string, params map[string]string) *http.Request {
+	t.Helper()
+
+	ctx, err := sessions.Load(r.Context(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions.Put(ctx, "userID", userID)
+
+	rctx := chi.NewRouteContext()
+	for k, v := range params {
+		rctx.URLParams.Add(k, v)
+	}
+
+	return r.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+}
+
+func TestConcurrentUplevelToggling(t *testing.T) {
+	pool := testDB(t)
+	ownerID := testUser(t, pool)
+	userID := testUser(t, pool)
+
+	ctx := context.Background()
+	var garbageID string
+	err := pool.QueryRow(ctx,
+		"INSERT INTO garbages(title, content, owner_id, published_at) VALUES ('Synergy', 'Circle back', $1, now()) RETURNING id",
+		ownerID).Scan(&garbageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := scs.New()
+	s := NewServer(app_config.Config{}, pool, sessions, nil, nil, newMarkdownRenderer(), testTemplates(t))
+
+	const goroutines, toggles = 16, 25
+	var wg sync.WaitGroup
+	for i := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range toggles {
+				method, handler := http.MethodPut, s.addUplevelHandler
+				if (i+j)%2 == 1 {
+					method, handler = http.MethodDelete, s.removeUplevelHandler
+				}
+
+				r := httptest.NewRequest(method, fmt.Sprintf("/garbage/%s/uplevel", garbageID), nil)
+				r = withSession(t, sessions, r, userID, map[string]string{"garbage_id": garbageID})
+				w := httptest.NewRecorder()
+				handler(w, r)
+
+				if w.Code != http.StatusOK {
+					t.Errorf("%s uplevel: status %d: %s", method, w.Code, w.Body)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	var uplevelCount, reactions int
+	err = pool.QueryRow(ctx,
+		`SELECT uplevel_count, (SELECT COUNT(*) FROM reactions WHERE garbage_id = garbages.id AND type = 'uplevel')
+		FROM garbages WHERE id = $1`,
+		garbageID).Scan(&uplevelCount, &reactions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uplevelCount != reactions {
+		t.Errorf("uplevel_count = %d, want the number of uplevels: %d", uplevelCount, reactions)
+	}
+
+	if reactions > 1 {
+		t.Errorf("user upleveled garbage %d times, want at most once", reactions)
+	}
+}
+
+func TestEditingGarbageKeepsItsLinkPreview(t *testing.T) {
+	pool := testDB(t)
+	ownerID := testUser(t, pool)
+
+	ctx := context.Background()
+	var garbageID string
+	err := pool.QueryRow(ctx,
+		`INSERT INTO garbages(title, content, url, link_preview, owner_id, published_at)
+		VALUES ('Synergy', 'Circle back', 'https://example.com/', '{"title": "Example"}', $1, now()) RETURNING id`,
+		ownerID).Scan(&garbageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		url         string
+		wantPreview bool
+	}{
+		{name: "URL unchanged", url: "https://EXAMPLE.com/#top", wantPreview: true},
+		{name: "URL changed", url: "https://example.org/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &fakeQueue{}
+			sessions := scs.New()
+			s := NewServer(app_config.Config{}, pool, sessions, queue, &fakeMailer{}, fakeRenderer{}, testTemplates(t))
+
+			form := url.Values{"title": {"Synergy"}, "garbage": {"Let's circle back"}, "url": {tt.url}}
+			r := httptest.NewRequest(http.MethodPut, "/garbage/"+garbageID, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r = withSession(t, sessions, r, ownerID, map[string]string{"garbage_id": garbageID})
+			w := httptest.NewRecorder()
+			s.editGarbageUpdateHandler(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+
+			var hasPreview bool
+			err := pool.QueryRow(ctx, "SELECT link_preview IS NOT NULL FROM garbages WHERE id = $1", garbageID).Scan(&hasPreview)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hasPreview != tt.wantPreview {
+				t.Errorf("garbage has a link preview = %v, want %v", hasPreview, tt.wantPreview)
+			}
+
+			if fetched := len(queue.jobs) > 0; fetched == tt.wantPreview {
+				t.Errorf("link preview fetched = %v, want %v", fetched, !tt.wantPreview)
+			}
+		})
+	}
+}
+
+func TestRenamingCollections(t *testing.T) {
+	pool := testDB(t)
+	ownerID := testUser(t, pool)
+	otherID := testUser(t, pool)
+
+	ctx := context.Background()
+	collection := func(name string, public bool) (collectionID string) {
+		t.Helper()
+
+		err := pool.QueryRow(ctx,
+			"INSERT INTO collections(owner_id, name, is_public) VALUES ($1, $2, $3) RETURNING id",
+			ownerID,
+			name,
+			public).Scan(&collectionID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+	public := collection("Synergy", true)
+	private := collection("Circle back", false)
+
+	tests := []struct {
+		name         string
+		userID       string
+		collectionID string
+		wantStatus   int
+		wantBody     string
+	}{
+		{
+			name:         "name of another of the user's collections",
+			userID:       ownerID,
+			collectionID: public,
+			wantStatus:   http.StatusOK,
+			wantBody:     "You already have a collection with that name.",
+		},
+		{name: "own collection", userID: ownerID, collectionID: private, wantStatus: http.StatusOK},
+		{name: "someone else's public collection", userID: otherID, collectionID: public, wantStatus: http.StatusForbidden},
+		{name: "someone else's private collection", userID: otherID, collectionID: private, wantStatus: http.StatusNotFound},
+		{
+			name:         "collection that doesn't exist",
+			userID:       ownerID,
+			collectionID: uuid.Must(uuid.NewV4()).String(),
+			wantStatus:   http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessions := scs.New()
+			s := NewServer(app_config.Config{}, pool, sessions, &fakeQueue{}, &fakeMailer{}, fakeRenderer{}, testTemplates(t))
+
+			form := url.Values{"name": {"Move the needle"}}
+			if tt.wantBody != "" {
+				form.Set("name", "Circle back")
+			}
+			r := httptest.NewRequest(http.MethodPut, "/collections/"+tt.collectionID, strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r = withSession(t, sessions, r, tt.userID, map[string]string{"collection_id": tt.collectionID})
+			w := httptest.NewRecorder()
+			s.updateCollectionHandler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body doesn't contain %q: %s", tt.wantBody, w.Body)
+			}
+		})
+	}
+}
+
+func TestWeeklyDigestIsSentOnce(t *testing.T) {
+	pool := testDB(t)
+	ownerID := testUser(t, pool)
+	userID := testUser(t, pool)
+
+	ctx := context.Background()
+	_, err := pool.Exec(ctx, "UPDATE users SET digest_opt_in = true WHERE id = $1", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = pool.Exec(ctx,
+		`INSERT INTO garbages(title, content, owner_id, published_at, uplevel_count)
+		VALUES ('Synergy', 'Circle back', $1, now(), 1)`,
+		ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// digests are of the week before the day they're sent
+	week := time.Now().UTC().AddDate(0, 0, 1).Format(time.DateOnly)
+	job := &jobs.Job{Queue: "weekly_digest_email", Payload: map[string]any{"user_id": userID, "week": week}}
+	jobCtx := jobs.WithJobContext(ctx, job)
+
+	mailer := &fakeMailer{err: errors.New("mail server unavailable")}
+	s := NewServer(app_config.Config{SecretKey: "secret"}, pool, scs.New(), &fakeQueue{}, mailer, fakeRenderer{}, testTemplates(t))
+
+	status := func() (status string) {
+		t.Helper()
+
+		err := pool.QueryRow(ctx, "SELECT status FROM digest_sends WHERE user_id = $1 AND week = $2", userID, week).Scan(&status)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return
+	}
+
+	if err := s.weeklyDigestEmailHandler(jobCtx); err == nil {
+		t.Fatal("digest that couldn't be sent didn't fail")
+	}
+	if got := status(); got != "failed" {
+		t.Errorf("status after failing to send = %q, want failed", got)
+	}
+
+	// digests that failed to send are sent when they're retried, and never again after they're sent
+	mailer.err = nil
+	for range 2 {
+		if err := s.weeklyDigestEmailHandler(jobCtx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := status(); got != "sent" {
+		t.Errorf("status after sending = %q, want sent", got)
+	}
+	if len(mailer.recipients) != 1 {
+		t.Errorf("digest was sent %d times, want once", len(mailer.recipients))
+	}
+
+	// digests whose outcome is unknown, e.g. because the job died while sending, aren't sent again
+	_, err = pool.Exec(ctx, "UPDATE digest_sends SET status = 'sending' WHERE user_id = $1 AND week = $2", userID, week)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.weeklyDigestEmailHandler(jobCtx); err != nil {
+		t.Fatal(err)
+	}
+	if len(mailer.recipients) != 1 {
+		t.Errorf("digest whose outcome is unknown was sent again")
+	}
+}
+
+// errFakeStore is returned by fakeStore for queries that tests don't expect
+var errFakeStore = errors.New("fake store: unexpected query")
+
+// execCall is a statement executed on fakeStore
+type execCall struct {
+	sql  string
+	args []any
+}
+
+// fakeStore is a Store that records the statements executed on it. QueryRows are answered with rows, in order, and
+// then with row; Queries are answered with results, in order, and then with errFakeStore. Transactions run against the
+// store itself, and are recorded as committed or rolled back.
+type fakeStore struct {
+	mu         sync.Mutex
+	execs      []execCall
+	row        []any
+	rows       [][]any
+	results    []*fakeRows
+	committed  int
+	rolledBack int
+}
+
+func (f *fakeStore) Exec(_ context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.execs = append(f.execs, execCall{sql: sql, args: args})
+
+	return pgconn.NewCommandTag("UPDATE 1"), nil
+}
+
+func (f *fakeStore) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.results) == 0 {
+		return nil, errFakeStore
+	}
+
+	rows := f.results[0]
+	f.results = f.results[1:]
+	return rows, nil
+}
+
+func (f *fakeStore) QueryRow(context.Context, string, ...any) pgx.Row {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.rows) == 0 {
+		return fakeRow{values: f.row}
+	}
+
+	row := fakeRow{values: f.rows[0]}
+	f.rows = f.rows[1:]
+	return row
+}
+
+func (f *fakeStore) Begin(context.Context) (pgx.Tx, error) {
+	return &fakeTx{store: f}, nil
+}
+
+// fakeTx is a transaction on fakeStore. Only the methods that the server uses are implemented; the others panic.
+type fakeTx struct {
+	pgx.Tx
+	store  *fakeStore
+	closed bool
+}
+
+func (tx *fakeTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	return tx.store.Exec(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	return tx.store.Query(ctx, sql, args...)
+}
+
+func (tx *fakeTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return tx.store.QueryRow(ctx, sql, args...)
+}
+
+func (tx *fakeTx) Commit(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+
+	tx.closed = true
+	tx.store.mu.Lock()
+	defer tx.store.mu.Unlock()
+	tx.store.committed++
+
+	return nil
+}
+
+func (tx *fakeTx) Rollback(context.Context) error {
+	if tx.closed {
+		return pgx.ErrTxClosed
+	}
+
+	tx.closed = true
+	tx.store.mu.Lock()
+	defer tx.store.mu.Unlock()
+	tx.store.rolledBack++
+
+	return nil
+}
+
+// fakeRow scans values into its destinations, or reports that there are no rows if it has none
+type fakeRow struct {
+	values []any
+}
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.values == nil {
+		return pgx.ErrNoRows
+	}
+
+	return scanValues(r.values, dest)
+}
+
+// fakeRows are the rows of a query's result, with the given columns
+type fakeRows struct {
+	pgx.Rows
+	columns []string
+	values  [][]any
+	current []any
+}
+
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription {
+	fields := make([]pgconn.FieldDescription, len(r.columns))
+	for i, column := range r.columns {
+		fields[i].Name = column
+	}
+
+	return fields
+}
+
+func (r *fakeRows) Next() bool {
+	if len(r.values) == 0 {
+		return false
+	}
+
+	r.current = r.values[0]
+	r.values = r.values[1:]
+	return true
+}
+
+func (r *fakeRows) Scan(dest ...any) error {
+	return scanValues(r.current, dest)
+}
+
+func (r *fakeRows) Err() error { return nil }
+
+func (r *fakeRows) Close() {}
+
+// scanValues sets each of dest to the corresponding value
+func scanValues(values []any, dest []any) error {
+	if len(dest) != len(values) {
+		return fmt.Errorf("fake row has %d values, scanned into %d destinations", len(values), len(dest))
+	}
+
+	for i, v := range values {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+
+	return nil
+}
+
+// fakeQueue is a Queue that records the jobs queued on it. While err is set, queueing fails with it instead.
+type fakeQueue struct {
+	mu   sync.Mutex
+	jobs []*jobs.Job
+	err  error
+}
+
+func (f *fakeQueue) Enqueue(_ context.Context, job *jobs.Job) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return "", f.err
+	}
+	f.jobs = append(f.jobs, job)
+
+	return fmt.Sprint(len(f.jobs)), nil
+}
+
+// fakeMailer is a Mailer that records the recipients of the email sent with it. While err is set, sending fails with
+// it instead.
+type fakeMailer struct {
+	mu         sync.Mutex
+	recipients []string
+	err        error
+}
+
+func (f *fakeMailer) Send(recipient, _, _ string, _ map[string]string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.recipients = append(f.recipients, recipient)
+
+	return nil
+}
+
+// fakeRenderer is a Renderer that wraps markdown in a paragraph, verbatim
+type fakeRenderer struct{}
+
+func (fakeRenderer) Render(markdown string) string {
+	return "<p>" + markdown + "</p>"
+}
+
+func TestRouter(t *testing.T) {
+	const userID = "9a0e9ad3-5b6f-4c1d-8f0e-3f1c2b4a5d6e"
+	const garbageID = "0b9c7a1e-2d3f-4e5a-9b8c-7d6e5f4a3b2c"
+	const survivorID = "5c1d8e2f-3a4b-4c6d-8e9f-0a1b2c3d4e5f"
+	const ownerID = "7e6d5c4b-3a29-4187-9f6e-5d4c3b2a1908"
+
+	// uplevelButton returns the garbage that's queried to render its uplevel button
+	uplevelButton := func(count int, upleveled bool) *fakeRows {
+		return &fakeRows{
+			columns: []string{"id", "uplevel_count", "upleveled"},
+			values:  [][]any{{uuid.FromStringOrNil(garbageID), count, upleveled}},
+		}
+	}
+
+	config := app_config.Config{SiteDomain: "garbagespeak.example", SecretKey: "secret", ReactionTypes: "kudos:Kudos"}
+	signature := NewServer(config, nil, nil, nil, nil, nil, nil).unsubscribeSignature(userID)
+	unsubscribeURL := fmt.Sprintf("/users/%s/unsubscribe/%s", userID, signature)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		form       string
+		loggedIn   bool
+		row        []any
+		rows       [][]any
+		results    []*fakeRows
+		wantStatus int
+		wantBody   string
+		wantExecs  []string // the statements that must be executed, by prefix
+		wantCommit bool     // whether a transaction must be committed
+	}{
+		{
+			name:       "health check",
+			method:     http.MethodGet,
+			path:       "/healthz",
+			wantStatus: http.StatusOK,
+			wantBody:   "ok",
+		},
+		{
+			name:       "metrics aren't public",
+			method:     http.MethodGet,
+			path:       "/metrics",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "markdown preview",
+			method:     http.MethodPost,
+			path:       "/garbage/preview",
+			form:       "garbage=synergy",
+			wantStatus: http.StatusOK,
+			wantBody:   "<p>synergy</p>",
+		},
+		{
+			name:       "unsubscribe page",
+			method:     http.MethodGet,
+			path:       unsubscribeURL,
+			wantStatus: http.StatusOK,
+			wantBody:   `action="https://garbagespeak.example` + unsubscribeURL + `"`,
+		},
+		{
+			name:       "unsubscribe",
+			method:     http.MethodPost,
+			path:       unsubscribeURL,
+			wantStatus: http.StatusOK,
+			wantBody:   "Unsubscribed",
+			wantExecs:  []string{"UPDATE users SET digest_opt_in = false"},
+		},
+		{
+			name:       "one-click unsubscribe",
+			method:     http.MethodPost,
+			path:       unsubscribeURL,
+			form:       "List-Unsubscribe=One-Click",
+			wantStatus: http.StatusOK,
+			wantBody:   "Unsubscribed",
+			wantExecs:  []string{"UPDATE users SET digest_opt_in = false"},
+		},
+		{
+			name:       "unsubscribe with an invalid signature",
+			method:     http.MethodPost,
+			path:       fmt.Sprintf("/users/%s/unsubscribe/%s", userID, "forged"),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "bookmark anonymously",
+			method:     http.MethodPut,
+			path:       "/garbage/" + garbageID + "/bookmark",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "merge anonymously",
+			method:     http.MethodPost,
+			path:       "/garbage/" + garbageID + "/merge",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "merge without being a moderator",
+			method:     http.MethodPost,
+			path:       "/garbage/" + garbageID + "/merge",
+			loggedIn:   true,
+			row:        []any{false},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "log in with an unknown username",
+			method:     http.MethodPost,
+			path:       "/users/login",
+			form:       "username=nobody&password=hunter22",
+			wantStatus: http.StatusOK,
+			wantBody:   "Incorrect username or password",
+		},
+		{
+			name:       "configured reaction",
+			method:     http.MethodDelete,
+			path:       "/garbage/" + garbageID + "/reactions/kudos",
+			loggedIn:   true,
+			row:        []any{3, false},
+			wantStatus: http.StatusOK,
+			wantBody:   "Kudos <b>3</b>",
+			wantExecs:  []string{"DELETE FROM reactions"},
+		},
+		{
+			name:       "default reaction that isn't configured",
+			method:     http.MethodDelete,
+			path:       "/garbage/" + garbageID + "/reactions/synergy",
+			loggedIn:   true,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "follow a user who doesn't exist",
+			method:     http.MethodPut,
+			path:       "/users/" + garbageID + "/follow",
+			loggedIn:   true,
+			row:        []any{false},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "follow a malformed user ID",
+			method:     http.MethodPut,
+			path:       "/users/nobody/follow",
+			loggedIn:   true,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "edit garbage to be too short",
+			method:     http.MethodPut,
+			path:       "/garbage/" + garbageID,
+			form:       "title=Synergy&garbage=0123456789",
+			loggedIn:   true,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "That garbage is too short.",
+		},
+		{
+			name:       "uplevel",
+			method:     http.MethodPut,
+			path:       "/garbage/" + garbageID + "/uplevel",
+			loggedIn:   true,
+			rows:       [][]any{{ownerID}},
+			results:    []*fakeRows{uplevelButton(4, true)},
+			wantStatus: http.StatusOK,
+			wantBody:   "Upleveled <b>4</b>",
+			wantExecs:  []string{"INSERT INTO reactions", "INSERT INTO notifications"},
+			wantCommit: true,
+		},
+		{
+			name:       "remove an uplevel",
+			method:     http.MethodDelete,
+			path:       "/garbage/" + garbageID + "/uplevel",
+			loggedIn:   true,
+			results:    []*fakeRows{uplevelButton(3, false)},
+			wantStatus: http.StatusOK,
+			wantBody:   "Uplevel <b>3</b>",
+			wantExecs:  []string{"DELETE FROM reactions", "DELETE FROM notifications"},
+			wantCommit: true,
+		},
+		{
+			name:       "edit garbage",
+			method:     http.MethodPut,
+			path:       "/garbage/" + garbageID,
+			form:       "title=Synergy&garbage=Let's circle back",
+			loggedIn:   true,
+			rows:       [][]any{{""}},
+			wantStatus: http.StatusOK,
+			wantExecs:  []string{"UPDATE garbages SET (title, content"},
+			wantCommit: true,
+		},
+		{
+			name:       "edit someone else's garbage",
+			method:     http.MethodPut,
+			path:       "/garbage/" + garbageID,
+			form:       "title=Synergy&garbage=Let's circle back",
+			loggedIn:   true,
+			wantStatus: http.StatusNotFound,
+			wantBody:   "That garbage doesn&#39;t exist.",
+		},
+		{
+			name:       "merge",
+			method:     http.MethodPost,
+			path:       "/garbage/" + garbageID + "/merge",
+			form:       "into=" + survivorID,
+			loggedIn:   true,
+			rows:       [][]any{{true}, {2}, {ownerID}},
+			wantStatus: http.StatusOK,
+			wantExecs: []string{
+				"INSERT INTO reactions",
+				"DELETE FROM reactions",
+				"INSERT INTO bookmarks",
+				"INSERT INTO collection_items",
+				"UPDATE featured",
+				"UPDATE garbage_merges",
+				"INSERT INTO garbage_merges",
+				"INSERT INTO notifications",
+				"DELETE FROM garbages",
+			},
+			wantCommit: true,
+		},
+		{
+			name:       "merge into garbage that doesn't exist",
+			method:     http.MethodPost,
+			path:       "/garbage/" + garbageID + "/merge",
+			form:       "into=" + survivorID,
+			loggedIn:   true,
+			rows:       [][]any{{true}, {1}},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "One of the posts being merged doesn&#39;t exist.",
+		},
+		{
+			name:       "create an account without a username",
+			method:     http.MethodPost,
+			path:       "/users/create",
+			form:       "password=hunter22hunter22&email=jane@garbagespeak.example",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Please choose a username.",
+		},
+		{
+			name:       "create an account with a short password",
+			method:     http.MethodPost,
+			path:       "/users/create",
+			form:       "username=jane&password=hunter2&email=jane@garbagespeak.example",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Passwords must be at least 8 characters long.",
+		},
+		{
+			name:       "create an account with an invalid email address",
+			method:     http.MethodPost,
+			path:       "/users/create",
+			form:       "username=jane&password=hunter22hunter22&email=jane",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Please enter a valid email address.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{row: tt.row, rows: tt.rows, results: tt.results}
+			queue := &fakeQueue{}
+			mailer := &fakeMailer{}
+			sessions := scs.New()
+			s := NewServer(config, store, sessions, queue, mailer, fakeRenderer{}, testTemplates(t),
+				WithMetrics(metrics.New()))
+
+			server := httptest.NewServer(s.Router())
+			defer server.Close()
+
+			req, err := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.form))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.form != "" {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+
+			if tt.loggedIn {
+				ctx, err := sessions.Load(context.Background(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				sessions.Put(ctx, "userID", userID)
+
+				token, _, err := sessions.Commit(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.AddCookie(&http.Cookie{Name: sessions.Cookie.Name, Value: token})
+			}
+
+			res, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			var body bytes.Buffer
+			body.ReadFrom(res.Body)
+
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", res.StatusCode, tt.wantStatus, body.String())
+			}
+
+			if !strings.Contains(body.String(), tt.wantBody) {
+				t.Errorf("body doesn't contain %q:\n%s", tt.wantBody, body.String())
+			}
+
+			if len(store.execs) != len(tt.wantExecs) {
+				t.Fatalf("executed %d statements, want %d: %+v", len(store.execs), len(tt.wantExecs), store.execs)
+			}
+			for i, want := range tt.wantExecs {
+				if !strings.HasPrefix(strings.TrimSpace(store.execs[i].sql), want) {
+					t.Errorf("statement %d = %q, want it to start with %q", i, store.execs[i].sql, want)
+				}
+			}
+
+			if committed := store.committed > 0; committed != tt.wantCommit {
+				t.Errorf("committed = %t, want %t", committed, tt.wantCommit)
+			}
+
+			// none of these requests queue jobs or send email
+			if len(queue.jobs) > 0 || len(mailer.recipients) > 0 {
+				t.Errorf("queued %d jobs and sent %d emails, want none", len(queue.jobs), len(mailer.recipients))
+			}
+		})
+	}
+}
+
+func TestCreateAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		queueErr   error
+		wantStatus int
+		wantBody   string
+		wantJobs   int
+	}{
+		{
+			name:       "welcome email queued",
+			wantStatus: http.StatusOK,
+			wantBody:   "Your account has been created.",
+			wantJobs:   1,
+		},
+		{
+			name:       "welcome email not queued",
+			queueErr:   errors.New("queue unavailable"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "Something went wrong.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeStore{rows: [][]any{{"9a0e9ad3-5b6f-4c1d-8f0e-3f1c2b4a5d6e"}, {"3f1c2b4a-5d6e-4c1d-8f0e-9a0e9ad35b6f"}}}
+			queue := &fakeQueue{err: tt.queueErr}
+			s := NewServer(app_config.Config{}, store, scs.New(), queue, &fakeMailer{}, fakeRenderer{}, testTemplates(t))
+
+			r := httptest.NewRequest(http.MethodPost, "/users/create", strings.NewReader(url.Values{
+				"username": {"jane"},
+				"password": {"hunter22hunter22"},
+				"email":    {"jane@garbagespeak.example"},
+			}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			s.createAccountHandler(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body doesn't contain %q:\n%s", tt.wantBody, w.Body.String())
+			}
+
+			if store.committed != 1 {
+				t.Errorf("committed %d transactions, want the account to be committed", store.committed)
+			}
+
+			if len(queue.jobs) != tt.wantJobs {
+				t.Errorf("queued %d jobs, want %d", len(queue.jobs), tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestToAppError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "app error", err: badRequest("Please choose a username.", nil), wantStatus: http.StatusBadRequest},
+		{name: "wrapped app error", err: fmt.Errorf("unable to follow: %w", errForbidden), wantStatus: http.StatusForbidden},
+		{name: "missing row", err: pgx.ErrNoRows, wantStatus: http.StatusNotFound},
+		{name: "malformed ID", err: &pgconn.PgError{Code: "22P02"}, wantStatus: http.StatusNotFound},
+		{name: "other database error", err: &pgconn.PgError{Code: "23505"}, wantStatus: http.StatusInternalServerError},
+		{name: "other error", err: errors.New("synergy overload"), wantStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := toAppError(tt.err); got.Status != tt.wantStatus {
+				t.Errorf("toAppError(%v).Status = %d, want %d", tt.err, got.Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestMetricsServer(t *testing.T) {
+	s := NewServer(app_config.Config{}, &fakeStore{}, scs.New(), &fakeQueue{}, &fakeMailer{}, fakeRenderer{}, testTemplates(t))
+	s.metrics.Reacted("kudos")
+
+	server := httptest.NewServer(s.MetricsServer("").Handler)
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var body bytes.Buffer
+	body.ReadFrom(res.Body)
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	if !strings.Contains(body.String(), "kudos") {
+		t.Errorf("metrics don't include reactions:\n%s", body.String())
+	}
+}
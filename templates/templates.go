@@ -0,0 +1,171 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+
+	"github.com/acaloiaro/garbage_speak/html_parser"
+	"github.com/acaloiaro/garbage_speak/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// contentID is the ID of the layout element into which page content is spliced
+const contentID = "content"
+
+// Config configures Templates
+type Config struct {
+	// Partials contains the partial templates
+	Partials fs.FS
+
+	// Views are the sets of templates that are rendered together, by name. Each is a list of glob patterns within
+	// Partials, e.g. "collections": {"partials/collections/*.html", "partials/garbage/list.html"}. Templates are
+	// parsed into sets, rather than all together, because partials in different directories share names.
+	Views map[string][]string
+
+	// Funcs are the functions available to every template
+	Funcs template.FuncMap
+
+	// Layout contains the site's layout: the page into which page content is spliced
+	Layout fs.FS
+
+	// LayoutPath is the layout's path within Layout, e.g. public/index.html. The layout is built by Hugo, and may not
+	// exist in development, where Hugo serves the site from memory; without it, partials are rendered, but pages aren't.
+	LayoutPath string
+
+	// Reload re-parses templates and the layout from their file systems on every render, so that changes to them are
+	// rendered without restarting the server. Only what's rendered is re-parsed: the view for partials, and the layout
+	// for pages. It's meant for development, with file systems that read from disk.
+	Reload bool
+}
+
+// Templates renders partial templates, and full pages made by splicing content into the site's layout. Templates and
+// the layout are parsed once, when Templates is created, unless they're reloaded on every render.
+type Templates struct {
+	config Config
+	views  map[string]*template.Template
+	layout *html_parser.Layout
+}
+
+// New returns Templates whose views and layout have been parsed. Errors in any of them are returned, so that broken
+// templates are found when the server starts rather than when they're first rendered.
+func New(config Config) (t *Templates, err error) {
+	t = &Templates{config: config}
+	err = t.parse()
+	return
+}
+
+// parse parses every view and the layout
+func (t *Templates) parse() (err error) {
+	t.views = make(map[string]*template.Template, len(t.config.Views))
+	for name := range t.config.Views {
+		t.views[name], err = t.parseView(name)
+		if err != nil {
+			return
+		}
+	}
+
+	t.layout, err = t.parseLayout()
+	return
+}
+
+// parseView parses the named view's templates
+func (t *Templates) parseView(name string) (tmpl *template.Template, err error) {
+	patterns, ok := t.config.Views[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown view: '%s'", name)
+	}
+
+	tmpl, err = template.New(name).Funcs(t.config.Funcs).ParseFS(t.config.Partials, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse view '%s': %w", name, err)
+	}
+
+	return
+}
+
+// parseLayout parses the layout, returning nil if it doesn't exist
+func (t *Templates) parseLayout() (layout *html_parser.Layout, err error) {
+	file, err := t.config.Layout.Open(t.config.LayoutPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to open layout '%s': %w", t.config.LayoutPath, err)
+	}
+	defer file.Close()
+
+	layout, err = html_parser.ParseLayout(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse layout '%s': %w", t.config.LayoutPath, err)
+	}
+
+	return
+}
+
+// view returns the named view, re-parsed if templates are reloaded on every render
+func (t *Templates) view(name string) (*template.Template, error) {
+	if t.config.Reload {
+		return t.parseView(name)
+	}
+
+	tmpl, ok := t.views[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown view: '%s'", name)
+	}
+
+	return tmpl, nil
+}
+
+// currentLayout returns the layout, re-parsed if templates are reloaded on every render
+func (t *Templates) currentLayout() (*html_parser.Layout, error) {
+	if t.config.Reload {
+		return t.parseLayout()
+	}
+
+	return t.layout, nil
+}
+
+// Render executes the named template from view with data, writing its output to w
+func (t *Templates) Render(ctx context.Context, w io.Writer, view, name string, data any) (err error) {
+	_, span := tracing.Start(ctx, "template "+name,
+		attribute.String("template.view", view),
+		attribute.String("template.name", name))
+	defer func() { tracing.End(span, err) }()
+
+	tmpl, err := t.view(view)
+	if err != nil {
+		return
+	}
+
+	return tmpl.ExecuteTemplate(w, name, data)
+}
+
+// Page renders a full page to w: the site's layout, with content as its main content. Pages with a title are titled
+// like the site's other pages, followed by the site's title, e.g. "Synergy :: garbage speak". Meta tags, such as the
+// page's description, are added to the layout's head.
+func (t *Templates) Page(ctx context.Context, w io.Writer, content, title string, meta ...html_parser.Meta) (err error) {
+	_, span := tracing.Start(ctx, "html_parser.Render")
+	defer func() { tracing.End(span, err) }()
+
+	layout, err := t.currentLayout()
+	if err != nil {
+		return
+	}
+	if layout == nil {
+		return fmt.Errorf("layout '%s' doesn't exist; build the site with hugo", t.config.LayoutPath)
+	}
+
+	if title != "" && layout.Title() != "" {
+		title = fmt.Sprintf("%s :: %s", title, layout.Title())
+	}
+
+	return layout.Render(w, html_parser.Page{
+		Regions: map[string]string{contentID: content},
+		Title:   title,
+		Meta:    meta,
+	})
+}
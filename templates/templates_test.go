@@ -0,0 +1,79 @@
+package templates
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReload(t *testing.T) {
+	partials := fstest.MapFS{
+		"partials/garbage/show.html": {Data: []byte(`{{ define "show.html" }}Synergy{{ end }}`)},
+		"partials/users/show.html":   {Data: []byte(`{{ define "show.html" }}Jane{{ end }}`)},
+	}
+	layout := fstest.MapFS{
+		"public/index.html": {Data: []byte(`<html><head><title>Garbage Speak</title></head><body><main id="content"></main></body></html>`)},
+	}
+
+	tmpls, err := New(Config{
+		Partials: partials,
+		Views: map[string][]string{
+			"garbage": {"partials/garbage/*.html"},
+			"users":   {"partials/users/*.html"},
+		},
+		Layout:     layout,
+		LayoutPath: "public/index.html",
+		Reload:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// views that aren't rendered aren't re-parsed, so one that's broken doesn't break the others
+	partials["partials/garbage/show.html"] = &fstest.MapFile{Data: []byte(`{{ define "show.html" }}Circle back{{ end }}`)}
+	partials["partials/users/show.html"] = &fstest.MapFile{Data: []byte(`{{ define "show.html" }}{{ end`)}
+
+	var buf bytes.Buffer
+	err = tmpls.Render(context.Background(), &buf, "garbage", "show.html", nil)
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+	if got := buf.String(); got != "Circle back" {
+		t.Errorf("Render() = %q, want the changed template", got)
+	}
+
+	err = tmpls.Render(context.Background(), &buf, "users", "show.html", nil)
+	if err == nil || !strings.Contains(err.Error(), "unable to parse view 'users'") {
+		t.Errorf("Render() error = %v, want the broken view not to parse", err)
+	}
+
+	layout["public/index.html"] = &fstest.MapFile{
+		Data: []byte(`<html><head><title>Garbage Speak</title></head><body><nav>Pivot</nav><main id="content"></main></body></html>`),
+	}
+
+	buf.Reset()
+	err = tmpls.Page(context.Background(), &buf, "<p>Synergy</p>", "")
+	if err != nil {
+		t.Fatalf("Page() error = %v", err)
+	}
+	if got := buf.String(); !strings.Contains(got, `<nav>Pivot</nav><main id="content"><p>Synergy</p></main>`) {
+		t.Errorf("Page() = %q, want the changed layout", got)
+	}
+}
+
+func TestRenderUnknownView(t *testing.T) {
+	for _, reload := range []bool{false, true} {
+		tmpls, err := New(Config{Partials: fstest.MapFS{}, Layout: fstest.MapFS{}, LayoutPath: "public/index.html", Reload: reload})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		err = tmpls.Render(context.Background(), &buf, "synergy", "show.html", nil)
+		if err == nil || err.Error() != "unknown view: 'synergy'" {
+			t.Errorf("Render() with Reload = %t error = %v, want unknown view", reload, err)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// ExporterNone disables tracing
+	ExporterNone = "none"
+	// ExporterOTLP exports spans to an OTLP collector over HTTP
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to stdout, for local use
+	ExporterStdout = "stdout"
+
+	// payloadKey is the job payload key under which the trace context of the code that queued a job is kept
+	payloadKey = "trace_context"
+
+	tracerName = "github.com/acaloiaro/garbage_speak"
+)
+
+// tracer is the tracer with which the server's spans are recorded. It uses the global tracer provider, so spans are
+// recorded by whichever provider Setup installs, and are dropped until then.
+var tracer = otel.Tracer(tracerName)
+
+// Setup installs a tracer provider that sends spans to exporter: ExporterOTLP sends them to the OTLP/HTTP collector at
+// endpoint, e.g. http://localhost:4318, and ExporterStdout writes them to stdout. With ExporterNone, spans are dropped.
+// The returned shutdown function flushes spans that haven't been exported yet.
+func Setup(ctx context.Context, exporter, endpoint, serviceName, serviceVersion, environment string) (shutdown func(context.Context) error, err error) {
+	shutdown = func(context.Context) error { return nil }
+
+	var spanExporter sdktrace.SpanExporter
+	switch exporter {
+	case ExporterNone:
+		return
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		err = fmt.Errorf("unknown trace exporter: '%s'", exporter)
+	}
+	if err != nil {
+		return
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(serviceVersion),
+			semconv.DeploymentEnvironment(environment),
+		)),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span, returning a context that contains it
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends a span, marking it failed if err isn't nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Middleware records a span for every request, continuing the trace of the client that made it, if there is one.
+// Spans are named after the chi route pattern that served the request, e.g. GET /garbage/{garbage_id}.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+			))
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		if rctx := chi.RouteContext(ctx); rctx != nil && rctx.RoutePattern() != "" {
+			span.SetName(fmt.Sprintf("%s %s", r.Method, rctx.RoutePattern()))
+			span.SetAttributes(semconv.HTTPRoute(rctx.RoutePattern()))
+		}
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// QueryTracer records a span for every pgx query
+type QueryTracer struct{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	ctx, _ = tracer.Start(ctx, "pgx.query",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			semconv.DBQueryText(data.SQL),
+		))
+
+	return ctx
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if data.Err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+
+	End(span, data.Err)
+}
+
+// Payload returns a copy of a job's payload that carries ctx's trace context, so that the job's span belongs to the
+// trace of the code that queued it. Payloads are fingerprinted to prevent duplicate jobs, so jobs must be
+// fingerprinted before their payload is replaced.
+func Payload(ctx context.Context, payload map[string]any) map[string]any {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+
+	withContext := make(map[string]any, len(payload)+1)
+	for k, v := range payload {
+		withContext[k] = v
+	}
+	if len(carrier) > 0 {
+		traceContext := make(map[string]any, len(carrier))
+		for k, v := range carrier {
+			traceContext[k] = v
+		}
+		withContext[payloadKey] = traceContext
+	}
+
+	return withContext
+}
+
+// Job wraps a neoq job handler, recording a span for each of the job's runs. Jobs queued with a trace context in their
+// payload continue that trace.
+func Job(queue string, handle func(ctx context.Context) error) func(ctx context.Context) error {
+	return func(ctx context.Context) (err error) {
+		attrs := []attribute.KeyValue{attribute.String("job.queue", queue)}
+		if j, jerr := jobs.FromContext(ctx); jerr == nil {
+			attrs = append(attrs, attribute.Int64("job.id", j.ID))
+			if traceContext, ok := j.Payload[payloadKey].(map[string]any); ok {
+				carrier := propagation.MapCarrier{}
+				for k, v := range traceContext {
+					if s, ok := v.(string); ok {
+						carrier[k] = s
+					}
+				}
+				ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+			}
+		}
+
+		ctx, span := tracer.Start(ctx, fmt.Sprintf("job %s", queue),
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attrs...))
+		defer func() { End(span, err) }()
+
+		return handle(ctx)
+	}
+}
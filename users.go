@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acaloiaro/neoq/jobs"
+	"github.com/georgysavva/scany/v2/pgxscan"
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// User represents 'user' records from the database
+type User struct {
+	ID           uuid.UUID
+	Username     string
+	PasswordHash string
+	Email        string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// UserEmailVerification models pending user email verifications. If a User has a UserEmailVeritifcation, the account is
+// pending verification and is not eligible to log in
+type UserEmailVerification struct {
+	ID        uuid.UUID
+	User      *User
+	UserID    uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// newUserValidationHandler checks whether a username is available during account creation
+func (s *Server) newUserValidationHandler(w http.ResponseWriter, r *http.Request) {
+	tmplVars := map[string]any{"ApiBaseUrl": s.config.APIURL()}
+
+	errCnt := 0
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	email := r.PostForm.Get("email")
+	password := r.PostForm.Get("password")
+	passwordConfirmation := r.PostForm.Get("password_confirmation")
+
+	tmplVars["Username"] = username
+	tmplVars["Email"] = email
+	tmplVars["Password"] = password
+	tmplVars["PasswordConfirmation"] = passwordConfirmation
+
+	if len(username) == 0 {
+		tmplVars["UsernameError"] = "Please choose a username"
+		errCnt += 1
+	} else if len(username) > 0 {
+		var userID string
+		s.store.QueryRow(r.Context(), "SELECT id FROM users WHERE username = $1", username).Scan(&userID)
+		if userID != "" {
+			tmplVars["UsernameError"] = fmt.Sprintf("Username '%s' is unavailable. Choose a different username.", username)
+			errCnt += 1
+		}
+	}
+
+	if (len(email) > 0 && len(email) < 4) || (len(email) >= 4 && !strings.Contains(email, "@")) {
+		tmplVars["EmailError"] = "Please enter a valid email address."
+		errCnt += 1
+	}
+
+	if len(password) > 0 && len(password) < 8 {
+		tmplVars["PasswordError"] = "Please choose a password greater than 8 characters"
+		errCnt += 1
+	}
+
+	if len(password) > 0 && len(passwordConfirmation) > 0 && password != passwordConfirmation {
+		tmplVars["PasswordConfirmationError"] = "Passwords do not match"
+		errCnt += 1
+	}
+
+	tmplVars["ErrorCount"] = errCnt
+
+	err := s.writeTemplate(r.Context(), w, "users", "new_user_validation.html", tmplVars)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// loginHandler handles login requests and performs validation
+func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	password := r.PostForm.Get("password")
+
+	var userID string
+	var storedPasswordHash string
+
+	// this anti-join prevents users with pending user email verifications from logging in
+	antiJoinQuery := `SELECT users.id, password FROM users
+LEFT JOIN user_email_verifications ON user_email_verifications.user_id = users.id
+WHERE user_email_verifications.id IS NULL
+AND username = $1`
+
+	var err error
+	err = s.store.QueryRow(r.Context(), antiJoinQuery, username).Scan(&userID, &storedPasswordHash)
+	// unknown usernames are refused like incorrect passwords, so that logging in doesn't reveal who has an account
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		s.renderError(w, r, err)
+		return
+	}
+
+	if err == nil && bcrypt.CompareHashAndPassword([]byte(storedPasswordHash), []byte(password)) == nil {
+		err = s.sessions.RenewToken(r.Context())
+		if err != nil {
+			s.renderError(w, r, err)
+			return
+		}
+
+		s.sessions.Put(r.Context(), "userID", userID)
+		w.Header().Add("hx-location", s.config.AppURL())
+
+		return
+	}
+
+	err = s.writeTemplate(r.Context(), w, "users", "login_validation.html", map[string]any{
+		"ApiBaseURL": s.config.APIURL(),
+		"LoginError": "Incorrect username or password",
+		"Username":   username,
+		"Password":   password,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// logoutHandler handles users logout requests
+func (s *Server) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	s.sessions.Destroy(r.Context())
+
+	w.Header().Add("hx-location", s.config.AppURL())
+	w.WriteHeader(http.StatusOK)
+}
+
+// navUserItems returns a nav items depending on whether the user is logged in
+func (s *Server) navUserItems(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if isLoggedIn(r) {
+		err = s.writeTemplate(r.Context(), w, "nav", "user_nav_items.html", map[string]any{
+			"ApiURL":      s.config.APIURL(),
+			"UnreadCount": s.unreadNotificationCount(r.Context(), s.sessions.GetString(r.Context(), "userID")),
+		})
+	} else {
+		err = s.writeTemplate(r.Context(), w, "nav", "non_user_nav_items.html", map[string]any{"ApiURL": s.config.APIURL()})
+	}
+
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// creatAccountPageHandler serves the new account form
+func (s *Server) creatAccountPageHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.writeTemplate(r.Context(), w, "users", "create.html", map[string]any{})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// emailVerification takes a UserEmailVerification ID, and if it exists, verifies the associated User account by
+// deleting the UEV record (user login's check if a UEV exists for a User before permitting login)
+func (s *Server) emailVerification(w http.ResponseWriter, r *http.Request) {
+	// user email verification id
+	uevID := chi.URLParam(r, "uev_id")
+
+	tx, err := s.store.Begin(r.Context())
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(r.Context())
+
+	var userID string
+	err = tx.QueryRow(r.Context(), "DELETE FROM user_email_verifications WHERE id = $1 RETURNING user_id", uevID).Scan(&userID)
+	if err != nil {
+		goto render
+	}
+
+	err = s.sessions.RenewToken(r.Context())
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	// create the user's session
+	s.sessions.Put(r.Context(), "userID", userID)
+
+	tx.Commit(r.Context())
+
+render:
+	http.Redirect(w, r, s.config.AppURL(), http.StatusFound)
+}
+
+// createAccountHandler creates new accounts
+func (s *Server) createAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	username := r.PostForm.Get("username")
+	if len(username) == 0 {
+		s.renderError(w, r, badRequest("Please choose a username.", nil))
+		return
+	}
+
+	password := r.PostForm.Get("password")
+	if len(password) < 8 {
+		s.renderError(w, r, badRequest("Passwords must be at least 8 characters long.", nil))
+		return
+	}
+
+	email := r.PostForm.Get("email")
+	if !strings.Contains(email, "@") {
+		s.renderError(w, r, badRequest("Please enter a valid email address.", nil))
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	ctx := context.WithoutCancel(r.Context())
+	tx, err := s.store.Begin(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	// Rollback is safe to call even if the tx is already closed, so if
+	// the tx commits successfully, this is a no-op
+	defer tx.Rollback(ctx)
+
+	var userID string
+	err = tx.QueryRow(ctx, "insert into users(username, password, email) values ($1, $2, $3) returning id", username, passwordHash, email).Scan(&userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	var uevID string
+	err = tx.QueryRow(ctx, "insert into user_email_verifications(user_id) values ($1) returning id", userID).Scan(&uevID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+	s.metrics.SignedUp()
+
+	err = s.enqueue(ctx, &jobs.Job{
+		Queue: "welcome_email",
+		Payload: map[string]interface{}{
+			"recipient":        email,
+			"verification_url": fmt.Sprintf("%s/users/email_verification/%s", s.config.APIURL(), uevID),
+		},
+	})
+	if err != nil {
+		s.renderError(w, r, fmt.Errorf("unable to queue email verification: %w", err))
+		return
+	}
+
+	err = s.writeTemplate(r.Context(), w, "users", "created.html", map[string]any{"Email": email})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// profileHandler returns a user's profile, along with their latest garbage
+func (s *Server) profileHandler(w http.ResponseWriter, r *http.Request) {
+	profileID := chi.URLParam(r, "user_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	ctx := r.Context()
+
+	user := User{}
+	err := pgxscan.Get(ctx, s.store, &user, "SELECT id, username, created_at FROM users WHERE id = $1", profileID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		s.renderError(w, r, notFound("That user doesn't exist.", err))
+		return
+	}
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	follows := struct {
+		FollowerCount int
+		FolloweeCount int
+		Following     bool
+	}{}
+	err = pgxscan.Get(ctx, s.store, &follows,
+		`SELECT
+			(SELECT COUNT(*) FROM follows WHERE followee_id = $2) AS follower_count,
+			(SELECT COUNT(*) FROM follows WHERE follower_id = $2) AS followee_count,
+			EXISTS (SELECT 1 FROM follows WHERE followee_id = $2 AND follower_id = NULLIF($1, '')::uuid) AS following`,
+		userID,
+		profileID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	query := `SELECT
+			garbages.id, n, owner_id, username, title, rendered_content, render_version, metadata, url, link_preview, uplevel_count,
+			` + upleveledColumn + `, ` + bookmarkedColumn + `, ` + followingColumn + `,
+			reaction_counts, ` + reactionsColumn + `, garbages.created_at, published_at, publish_at
+			FROM garbages
+			JOIN users ON garbages.owner_id = users.id
+			WHERE owner_id = $2 AND published_at IS NOT NULL`
+	pagedQuery, args := pagedQuery(r, query, userID, profileID)
+
+	garbage := []*Garbage{}
+	err = pgxscan.Select(ctx, s.store, &garbage, pagedQuery, args...)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	var lastItem *int
+	if len(garbage) > 0 {
+		lastItem = &(garbage[len(garbage)-1].N)
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "users", "profile.html", map[string]any{
+		"User":          user,
+		"FollowerCount": follows.FollowerCount,
+		"FolloweeCount": follows.FolloweeCount,
+		"Following":     follows.Following,
+		"UserID":        userID,
+		"ApiBaseUrl":    s.config.APIURL(),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.templates.Render(r.Context(), buff, "users", "list.html", map[string]any{
+		"Posts":         garbage,
+		"ApiBaseUrl":    s.config.APIURL(),
+		"LoggedIn":      isLoggedIn(r),
+		"UserID":        userID,
+		"IsModerator":   s.isModerator(r),
+		"NextPage":      nextPageURL(r, fmt.Sprintf("%s/users/%s", s.config.APIURL(), user.ID), lastItem),
+		"ReactionTypes": s.reactionTypes,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+func (s *Server) followHandler(w http.ResponseWriter, r *http.Request) {
+	followeeID := chi.URLParam(r, "user_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if followeeID == userID {
+		s.renderError(w, r, badRequest("You can't follow yourself.", nil))
+		return
+	}
+
+	var followeeExists bool
+	if _, err := uuid.FromString(followeeID); err == nil {
+		err = s.store.QueryRow(r.Context(), "SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)", followeeID).Scan(&followeeExists)
+		if err != nil {
+			s.renderError(w, r, err)
+			return
+		}
+	}
+
+	if !followeeExists {
+		s.renderError(w, r, notFound("That user doesn't exist.", nil))
+		return
+	}
+
+	_, err := s.store.Exec(r.Context(),
+		"INSERT INTO follows(follower_id, followee_id) VALUES ($1, $2) ON CONFLICT (follower_id, followee_id) DO NOTHING",
+		userID,
+		followeeID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderFollowButton(r.Context(), w, r, followeeID, userID)
+}
+
+func (s *Server) unfollowHandler(w http.ResponseWriter, r *http.Request) {
+	followeeID := chi.URLParam(r, "user_id")
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	_, err := s.store.Exec(r.Context(), "DELETE FROM follows WHERE follower_id = $1 AND followee_id = $2", userID, followeeID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.renderFollowButton(r.Context(), w, r, followeeID, userID)
+}
+
+// renderFollowButton renders the follow button of the followee as seen by the given user
+func (s *Server) renderFollowButton(ctx context.Context, w http.ResponseWriter, r *http.Request, followeeID, userID string) {
+	followee := struct {
+		Username  string
+		Following bool
+	}{}
+	err := pgxscan.Get(ctx, s.store, &followee,
+		`SELECT username, EXISTS (
+			SELECT 1 FROM follows WHERE followee_id = users.id AND follower_id = $1
+		) AS following
+		FROM users WHERE id = $2`,
+		userID,
+		followeeID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	err = s.writeTemplate(ctx, w, "garbage", "follow_button.tmpl", map[string]any{
+		"UserID":     userID,
+		"FolloweeID": followeeID,
+		"Username":   followee.Username,
+		"Following":  followee.Following,
+		"ApiBaseUrl": s.config.APIURL(),
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+}
+
+// settingsHandler serves the current user's settings
+func (s *Server) settingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	user := struct{ DigestOptIn bool }{}
+	err := pgxscan.Get(r.Context(), s.store, &user, "SELECT digest_opt_in FROM users WHERE id = $1", userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	buff := bytes.NewBufferString("")
+	err = s.templates.Render(r.Context(), buff, "users", "settings.html", map[string]any{
+		"ApiBaseUrl":  s.config.APIURL(),
+		"DigestOptIn": user.DigestOptIn,
+		"Saved":       r.Method == http.MethodPut,
+	})
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.writePage(w, r, buff)
+}
+
+// updateSettingsHandler saves the current user's settings
+func (s *Server) updateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := s.sessions.GetString(r.Context(), "userID")
+	if userID == "" {
+		s.renderError(w, r, errUnauthorized)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	_, err := s.store.Exec(r.Context(),
+		"UPDATE users SET digest_opt_in = $1 WHERE id = $2",
+		r.PostForm.Get("digest_opt_in") == "true",
+		userID)
+	if err != nil {
+		s.renderError(w, r, err)
+		return
+	}
+
+	s.settingsHandler(w, r)
+}